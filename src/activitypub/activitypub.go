@@ -0,0 +1,194 @@
+// Package activitypub makes the site an ActivityPub actor which can be
+// followed from the fediverse. Published posts are delivered to the
+// followers' inboxes. See https://www.w3.org/TR/activitypub/.
+package activitypub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"net/url"
+	"presence/model"
+	"presence/store"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of ActivityPub documents.
+const ContentType = "application/activity+json"
+
+const publicCollection = "https://www.w3.org/ns/activitystreams#Public"
+
+var context = []string{
+	"https://www.w3.org/ns/activitystreams",
+	"https://w3id.org/security/v1",
+}
+
+// Accepts checks if the request asks for an ActivityPub document rather than
+// a web page.
+func Accepts(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		if mt == ContentType ||
+			mt == "application/ld+json" && strings.Contains(params["profile"], "activitystreams") {
+			return true
+		}
+	}
+	return false
+}
+
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type Actor struct {
+	Context           []string  `json:"@context"`
+	ID                string    `json:"id"`
+	Type              string    `json:"type"`
+	PreferredUsername string    `json:"preferredUsername"`
+	Name              string    `json:"name"`
+	Summary           string    `json:"summary,omitempty"`
+	URL               string    `json:"url"`
+	Inbox             string    `json:"inbox"`
+	Outbox            string    `json:"outbox"`
+	Followers         string    `json:"followers"`
+	PublicKey         PublicKey `json:"publicKey"`
+}
+
+// Object is an ActivityStreams object or activity. Only the properties used
+// by the site are included.
+type Object struct {
+	Context      interface{} `json:"@context,omitempty"`
+	ID           string      `json:"id,omitempty"`
+	Type         string      `json:"type"`
+	Actor        string      `json:"actor,omitempty"`
+	Name         string      `json:"name,omitempty"`
+	Summary      string      `json:"summary,omitempty"`
+	Content      string      `json:"content,omitempty"`
+	URL          string      `json:"url,omitempty"`
+	Href         string      `json:"href,omitempty"`
+	AttributedTo string      `json:"attributedTo,omitempty"`
+	Published    string      `json:"published,omitempty"`
+	Updated      string      `json:"updated,omitempty"`
+	To           []string    `json:"to,omitempty"`
+	CC           []string    `json:"cc,omitempty"`
+	Tag          []*Object   `json:"tag,omitempty"`
+	Object       interface{} `json:"object,omitempty"`
+}
+
+type OrderedCollection struct {
+	Context      interface{}   `json:"@context"`
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	TotalItems   int           `json:"totalItems"`
+	OrderedItems []interface{} `json:"orderedItems"`
+}
+
+// Site holds the identity of the actor.
+type Site struct {
+	BaseURL  string
+	Username string
+}
+
+func (s *Site) ActorID() string      { return s.BaseURL + "/actor" }
+func (s *Site) KeyID() string        { return s.ActorID() + "#main-key" }
+func (s *Site) InboxURL() string     { return s.BaseURL + "/inbox" }
+func (s *Site) OutboxURL() string    { return s.BaseURL + "/outbox" }
+func (s *Site) FollowersURL() string { return s.BaseURL + "/followers" }
+
+// Handle returns the WebFinger account of the actor, without the "acct:"
+// prefix.
+func (s *Site) Handle() string {
+	host := s.BaseURL
+	if u, err := url.Parse(s.BaseURL); err == nil {
+		host = u.Hostname()
+	}
+	return s.Username + "@" + host
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Article returns the ActivityStreams representation of the post.
+func (s *Site) Article(a *model.Article) *Object {
+	id := s.BaseURL + "/" + a.Slug
+	obj := &Object{
+		ID:           id,
+		Type:         "Article",
+		Name:         a.Title,
+		Summary:      a.Description,
+		Content:      a.BodyHTML,
+		URL:          id,
+		AttributedTo: s.ActorID(),
+		Published:    formatTime(a.PubTime),
+		Updated:      formatTime(a.Updated),
+		To:           []string{publicCollection},
+		CC:           []string{s.FollowersURL()},
+	}
+	for _, tag := range a.Tags {
+		tag = store.NormalizeTag(tag)
+		obj.Tag = append(obj.Tag, &Object{
+			Type: "Hashtag",
+			Href: s.BaseURL + "/tag/" + url.PathEscape(tag),
+			Name: "#" + tag,
+		})
+	}
+	return obj
+}
+
+// activity wraps the object in an activity addressed like the object.
+func (s *Site) activity(typ, id string, obj *Object) *Object {
+	return &Object{
+		Context:   context,
+		ID:        id,
+		Type:      typ,
+		Actor:     s.ActorID(),
+		Published: obj.Published,
+		To:        obj.To,
+		CC:        obj.CC,
+		Object:    obj,
+	}
+}
+
+// Create returns the Create activity for the post.
+func (s *Site) Create(a *model.Article) *Object {
+	obj := s.Article(a)
+	return s.activity("Create", obj.ID+"#create", obj)
+}
+
+// Update returns the Update activity for the post. Each version of the
+// post gets its own activity ID.
+func (s *Site) Update(a *model.Article) *Object {
+	obj := s.Article(a)
+	act := s.activity("Update", obj.ID+"#update-"+contentHash(a), obj)
+	act.Published = formatTime(&a.ModTime)
+	return act
+}
+
+// Delete returns the Delete activity for the removed post.
+func (s *Site) Delete(a *model.Article) *Object {
+	id := s.BaseURL + "/" + a.Slug
+	obj := &Object{
+		ID:   id,
+		Type: "Tombstone",
+		To:   []string{publicCollection},
+		CC:   []string{s.FollowersURL()},
+	}
+	return s.activity("Delete", id+"#delete", obj)
+}
+
+// contentHash identifies the version of the post delivered to followers.
+func contentHash(a *model.Article) string {
+	sum := sha256.Sum256([]byte(a.Title + "\x00" + a.Description + "\x00" + a.BodyHTML))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,264 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"presence/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testKey *rsa.PrivateKey
+
+func init() {
+	var err error
+	if testKey, err = rsa.GenerateKey(rand.Reader, 1024); err != nil {
+		panic(err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"type":"Follow"}`)
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest("POST", "https://blog.example/inbox", bytes.NewReader(body))
+		if err := sign(r, "https://remote.example/actor#key", testKey, body); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := newRequest()
+	sig, err := parseSignature(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.keyID != "https://remote.example/actor#key" {
+		t.Errorf("unexpected key ID '%s'", sig.keyID)
+	}
+	if err := sig.checkHeaders(r, body); err != nil {
+		t.Error(err)
+	}
+	if err := sig.verify(r, &testKey.PublicKey); err != nil {
+		t.Error(err)
+	}
+
+	if err := sig.checkHeaders(r, []byte(`{"type":"Undo"}`)); !errors.Is(err, errInvalidSignature) {
+		t.Errorf("tampered body: want errInvalidSignature, got %v", err)
+	}
+	r.URL.Path = "/other"
+	if err := sig.verify(r, &testKey.PublicKey); err != errInvalidSignature {
+		t.Errorf("other target: want errInvalidSignature, got %v", err)
+	}
+
+	r = newRequest()
+	r.Header.Set("Date", time.Now().Add(-24*time.Hour).UTC().Format(http.TimeFormat))
+	sig, _ = parseSignature(r)
+	if err := sig.checkHeaders(r, body); !errors.Is(err, errInvalidSignature) {
+		t.Errorf("old date: want errInvalidSignature, got %v", err)
+	}
+
+	r.Header.Del("Signature")
+	if _, err := parseSignature(r); err != errNoSignature {
+		t.Errorf("want errNoSignature, got %v", err)
+	}
+}
+
+// remote is a stand-in for a fediverse server, hosting a single actor.
+type remote struct {
+	*httptest.Server
+	mux      sync.Mutex
+	status   int
+	received []*Object
+}
+
+func newRemote(t *testing.T) *remote {
+	rm := &remote{status: http.StatusAccepted}
+	pem, err := encodePublicKey(&testKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/alice":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":    rm.actorID(),
+				"type":  "Person",
+				"inbox": rm.URL + "/alice/inbox",
+				"publicKey": map[string]string{
+					"id":           rm.actorID() + "#main-key",
+					"owner":        rm.actorID(),
+					"publicKeyPem": pem,
+				},
+			})
+		case "/alice/inbox":
+			rm.mux.Lock()
+			defer rm.mux.Unlock()
+			if rm.status == http.StatusAccepted {
+				var obj Object
+				json.NewDecoder(r.Body).Decode(&obj)
+				rm.received = append(rm.received, &obj)
+			}
+			w.WriteHeader(rm.status)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return rm
+}
+
+func (rm *remote) actorID() string { return rm.URL + "/alice" }
+
+func (rm *remote) setStatus(code int) {
+	rm.mux.Lock()
+	defer rm.mux.Unlock()
+	rm.status = code
+}
+
+// types returns the types of the received activities.
+func (rm *remote) types() []string {
+	rm.mux.Lock()
+	defer rm.mux.Unlock()
+	var types []string
+	for _, obj := range rm.received {
+		types = append(types, obj.Type)
+	}
+	return types
+}
+
+// post posts the activity of the remote actor to the inbox of f.
+func (rm *remote) post(f *Federation, act map[string]interface{}) error {
+	act["actor"] = rm.actorID()
+	body, _ := json.Marshal(act)
+	r := httptest.NewRequest("POST", f.Site.InboxURL(), bytes.NewReader(body))
+	if err := sign(r, rm.actorID()+"#main-key", testKey, body); err != nil {
+		return err
+	}
+	return f.Receive(r, body)
+}
+
+// waitFor polls until the condition is met or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFederation(t *testing.T) {
+	retryDelay = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "presence_test_activitypub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rm := newRemote(t)
+	defer rm.Close()
+	// The remote is on the loopback address, which the default client
+	// refuses to connect to.
+	defer func(f func() *http.Client) { newClient = f }(newClient)
+	newClient = rm.Client
+
+	site := Site{BaseURL: "https://blog.example", Username: "blog"}
+	f, err := New(dir, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Posts existing on the first run aren't delivered.
+	old := &model.Article{Slug: "old", Title: "Old", BodyHTML: "<p>Old</p>"}
+	f.Sync([]*model.Article{old})
+
+	// Unsigned requests are rejected.
+	body := []byte(`{"type":"Follow","actor":"` + rm.actorID() + `","object":"` + site.ActorID() + `"}`)
+	r := httptest.NewRequest("POST", site.InboxURL(), bytes.NewReader(body))
+	if err := f.Receive(r, body); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("unsigned: want ErrUnauthorized, got %v", err)
+	}
+
+	err = rm.post(f, map[string]interface{}{
+		"id":     rm.URL + "/follows/1",
+		"type":   "Follow",
+		"object": site.ActorID(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fl := f.Followers(); len(fl) != 1 || fl[0].ID != rm.actorID() {
+		t.Fatalf("unexpected followers: %+v", fl)
+	}
+	waitFor(t, "accept", func() bool { return equal(rm.types(), []string{"Accept"}) })
+
+	post := &model.Article{Slug: "hello", Title: "Hello", BodyHTML: "<p>Hello</p>", Tags: []string{"Go"}}
+	f.Publish(post)
+	f.Publish(post)
+	waitFor(t, "create", func() bool { return equal(rm.types(), []string{"Accept", "Create"}) })
+	f.Close()
+
+	// Deliveries which fail are kept across restarts.
+	rm.setStatus(http.StatusServiceUnavailable)
+	f, err = New(dir, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fl := f.Followers(); len(fl) != 1 {
+		t.Fatalf("followers not persisted: %+v", fl)
+	}
+	post.BodyHTML = "<p>Hello, world</p>"
+	f.Publish(post)
+	waitFor(t, "failed attempt", func() bool {
+		f.mux.Lock()
+		defer f.mux.Unlock()
+		return len(f.queue) == 1 && f.queue[0].Attempts > 0
+	})
+	f.Close()
+
+	rm.setStatus(http.StatusAccepted)
+	f, err = New(dir, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	waitFor(t, "update", func() bool { return equal(rm.types(), []string{"Accept", "Create", "Update"}) })
+
+	// Changes made while stopped are delivered.
+	f.Sync(nil)
+	waitFor(t, "delete", func() bool {
+		return equal(rm.types(), []string{"Accept", "Create", "Update", "Delete", "Delete"})
+	})
+
+	err = rm.post(f, map[string]interface{}{
+		"type":   "Undo",
+		"object": rm.URL + "/follows/1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fl := f.Followers(); len(fl) != 0 {
+		t.Errorf("follower not removed: %+v", fl)
+	}
+}
@@ -0,0 +1,427 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"presence/atomicfile"
+	"presence/model"
+	"presence/safehttp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	keyFile       = "key.pem"
+	followersFile = "followers.json"
+	publishedFile = "published.json"
+	queueFile     = "queue.json"
+
+	fetchTimeout        = 10 * time.Second
+	maxDocumentSize     = 1 << 20
+	maxDeliveryAttempts = 8
+)
+
+// retryDelay is the delay before the first retry of a failed delivery. It
+// doubles with each attempt.
+var retryDelay = time.Minute
+
+// newClient creates the client fetching the remote actors and delivering the
+// activities, which only connects to public addresses.
+var newClient = func() *http.Client {
+	return safehttp.NewClient(fetchTimeout)
+}
+
+type Follower struct {
+	ID          string    `json:"id"`
+	Inbox       string    `json:"inbox"`
+	SharedInbox string    `json:"shared_inbox,omitempty"`
+	FollowID    string    `json:"follow_id,omitempty"` // ID of the Follow activity
+	Since       time.Time `json:"since"`
+}
+
+// delivery is an activity waiting to be posted to an inbox.
+type delivery struct {
+	Inbox       string          `json:"inbox"`
+	Activity    json.RawMessage `json:"activity"`
+	Attempts    int             `json:"attempts,omitempty"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// Federation holds the state of the actor: its key, its followers, the
+// versions of the posts already delivered to them and the queue of pending
+// deliveries. The state is persisted in JSON files in a directory, so that
+// nothing is lost or delivered twice across restarts. Failed deliveries are
+// retried with exponential backoff.
+type Federation struct {
+	Site   Site
+	Client *http.Client
+
+	dir       string
+	key       *rsa.PrivateKey
+	publicKey string
+	followers map[string]*Follower
+	published map[string]string // slug -> content hash
+	synced    bool              // whether published was loaded from a previous run
+	queue     []*delivery
+	actors    map[string]*remoteActor // key ID -> actor
+	wake      chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	mux       sync.Mutex
+}
+
+// New loads the state of the actor from dir, creating its key if needed, and
+// starts delivering the pending activities.
+func New(dir string, site Site) (*Federation, error) {
+	f := &Federation{
+		Site:      site,
+		Client:    newClient(),
+		dir:       dir,
+		followers: make(map[string]*Follower),
+		published: make(map[string]string),
+		actors:    make(map[string]*remoteActor),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	key, err := loadKey(filepath.Join(dir, keyFile))
+	if err != nil {
+		return nil, err
+	}
+	f.key = key
+	if f.publicKey, err = encodePublicKey(&key.PublicKey); err != nil {
+		return nil, err
+	}
+
+	if _, err := atomicfile.ReadJSON(filepath.Join(dir, followersFile), &f.followers); err != nil {
+		return nil, err
+	}
+	if f.synced, err = atomicfile.ReadJSON(filepath.Join(dir, publishedFile), &f.published); err != nil {
+		return nil, err
+	}
+	if _, err := atomicfile.ReadJSON(filepath.Join(dir, queueFile), &f.queue); err != nil {
+		return nil, err
+	}
+
+	f.wg.Add(1)
+	go f.run()
+
+	return f, nil
+}
+
+// Actor returns the actor document, with the given display name and summary.
+func (f *Federation) Actor(name, summary string) *Actor {
+	return &Actor{
+		Context:           context,
+		ID:                f.Site.ActorID(),
+		Type:              "Person",
+		PreferredUsername: f.Site.Username,
+		Name:              name,
+		Summary:           summary,
+		URL:               f.Site.BaseURL + "/",
+		Inbox:             f.Site.InboxURL(),
+		Outbox:            f.Site.OutboxURL(),
+		Followers:         f.Site.FollowersURL(),
+		PublicKey: PublicKey{
+			ID:           f.Site.KeyID(),
+			Owner:        f.Site.ActorID(),
+			PublicKeyPem: f.publicKey,
+		},
+	}
+}
+
+// Outbox returns the outbox collection, with the Create activities of the
+// given posts. total is the number of posts on the site.
+func (f *Federation) Outbox(articles []*model.Article, total int) *OrderedCollection {
+	items := make([]interface{}, len(articles))
+	for i, a := range articles {
+		act := f.Site.Create(a)
+		act.Context = nil
+		items[i] = act
+	}
+	return &OrderedCollection{
+		Context:      context[0],
+		ID:           f.Site.OutboxURL(),
+		Type:         "OrderedCollection",
+		TotalItems:   total,
+		OrderedItems: items,
+	}
+}
+
+// Followers returns the followers, oldest first.
+func (f *Federation) Followers() []*Follower {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	followers := make([]*Follower, 0, len(f.followers))
+	for _, fl := range f.followers {
+		c := *fl
+		followers = append(followers, &c)
+	}
+	sort.Slice(followers, func(i, j int) bool {
+		return followers[i].Since.Before(followers[j].Since)
+	})
+	return followers
+}
+
+// FollowersCollection returns the followers collection.
+func (f *Federation) FollowersCollection() *OrderedCollection {
+	followers := f.Followers()
+	items := make([]interface{}, len(followers))
+	for i, fl := range followers {
+		items[i] = fl.ID
+	}
+	return &OrderedCollection{
+		Context:      context[0],
+		ID:           f.Site.FollowersURL(),
+		Type:         "OrderedCollection",
+		TotalItems:   len(items),
+		OrderedItems: items,
+	}
+}
+
+// Publish delivers the post to the followers, as a Create activity if it's
+// new, or as an Update if it changed since it was last delivered.
+func (f *Federation) Publish(a *model.Article) {
+	hash := contentHash(a)
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	old, ok := f.published[a.Slug]
+	if ok && old == hash {
+		return
+	}
+	act := f.Site.Create(a)
+	if ok {
+		act = f.Site.Update(a)
+	}
+	f.published[a.Slug] = hash
+	f.savePublished()
+	f.deliver(act)
+}
+
+// Unpublish delivers a Delete activity for the removed post.
+func (f *Federation) Unpublish(a *model.Article) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if _, ok := f.published[a.Slug]; !ok {
+		return
+	}
+	delete(f.published, a.Slug)
+	f.savePublished()
+	f.deliver(f.Site.Delete(a))
+}
+
+// Sync catches up with the changes made to the posts while the server wasn't
+// running, given all the published posts. On the first run, the existing
+// posts are only recorded, not delivered.
+func (f *Federation) Sync(articles []*model.Article) {
+	f.mux.Lock()
+	if !f.synced {
+		for _, a := range articles {
+			f.published[a.Slug] = contentHash(a)
+		}
+		f.synced = true
+		f.savePublished()
+		f.mux.Unlock()
+		return
+	}
+	current := make(map[string]bool)
+	for _, a := range articles {
+		current[a.Slug] = true
+	}
+	var removed []string
+	for slug := range f.published {
+		if !current[slug] {
+			removed = append(removed, slug)
+		}
+	}
+	f.mux.Unlock()
+
+	for _, a := range articles {
+		f.Publish(a)
+	}
+	for _, slug := range removed {
+		f.Unpublish(&model.Article{Slug: slug})
+	}
+}
+
+// deliver queues the activity for all the followers, once per shared inbox.
+// The lock must be held.
+func (f *Federation) deliver(act *Object) {
+	inboxes := make(map[string]bool)
+	for _, fl := range f.followers {
+		if fl.SharedInbox != "" {
+			inboxes[fl.SharedInbox] = true
+		} else {
+			inboxes[fl.Inbox] = true
+		}
+	}
+	for inbox := range inboxes {
+		f.enqueue(inbox, act)
+	}
+}
+
+// enqueue queues the activity for the inbox. The lock must be held.
+func (f *Federation) enqueue(inbox string, act *Object) {
+	data, err := json.Marshal(act)
+	if err != nil {
+		log.Printf("couldn't encode activity '%s': %v\n", act.ID, err)
+		return
+	}
+	f.queue = append(f.queue, &delivery{
+		Inbox:       inbox,
+		Activity:    data,
+		NextAttempt: time.Now(),
+	})
+	f.saveQueue()
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// The save functions write the state files. The lock must be held.
+
+func (f *Federation) saveFollowers() {
+	if err := atomicfile.WriteJSON(filepath.Join(f.dir, followersFile), f.followers, 0644); err != nil {
+		log.Printf("couldn't save followers: %v\n", err)
+	}
+}
+
+func (f *Federation) savePublished() {
+	if err := atomicfile.WriteJSON(filepath.Join(f.dir, publishedFile), f.published, 0644); err != nil {
+		log.Printf("couldn't save published posts: %v\n", err)
+	}
+}
+
+func (f *Federation) saveQueue() {
+	if err := atomicfile.WriteJSON(filepath.Join(f.dir, queueFile), f.queue, 0644); err != nil {
+		log.Printf("couldn't save delivery queue: %v\n", err)
+	}
+}
+
+func (f *Federation) run() {
+	defer f.wg.Done()
+	for {
+		d, wait := f.next()
+		if d != nil && wait <= 0 {
+			f.send(d)
+			continue
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if d != nil {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-f.wake:
+		case <-timeout:
+		case <-f.done:
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// next returns the delivery with the earliest scheduled attempt, and the time
+// until the attempt. The delivery is nil if the queue is empty.
+func (f *Federation) next() (*delivery, time.Duration) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	var next *delivery
+	for _, d := range f.queue {
+		if next == nil || d.NextAttempt.Before(next.NextAttempt) {
+			next = d
+		}
+	}
+	if next == nil {
+		return nil, 0
+	}
+	return next, time.Until(next.NextAttempt)
+}
+
+// send posts the activity to the inbox, then removes it from the queue or
+// schedules a retry.
+func (f *Federation) send(d *delivery) {
+	retry, err := f.post(d.Inbox, d.Activity)
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	d.Attempts++
+	switch {
+	case err == nil:
+		log.Printf("delivered activity to '%s'\n", d.Inbox)
+	case retry && d.Attempts < maxDeliveryAttempts:
+		d.NextAttempt = time.Now().Add(retryDelay << uint(d.Attempts-1))
+		d.Error = err.Error()
+		log.Printf("couldn't deliver activity to '%s' (retrying at %s): %v\n",
+			d.Inbox, d.NextAttempt.Format(time.RFC3339), err)
+		f.saveQueue()
+		return
+	default:
+		log.Printf("couldn't deliver activity to '%s': %v\n", d.Inbox, err)
+	}
+	for i, q := range f.queue {
+		if q == d {
+			f.queue = append(f.queue[:i:i], f.queue[i+1:]...)
+			break
+		}
+	}
+	f.saveQueue()
+}
+
+// isTemporary checks if the request should be retried after a response with
+// the status code.
+func isTemporary(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500
+}
+
+// post sends the signed activity to the inbox. The bool is true if a failed
+// request should be retried.
+func (f *Federation) post(inbox string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if err := sign(req, f.Site.KeyID(), f.key, body); err != nil {
+		return false, err
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDocumentSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return isTemporary(resp.StatusCode), fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Close stops delivering activities. The pending ones are delivered after
+// the next start.
+func (f *Federation) Close() {
+	close(f.done)
+	f.wg.Wait()
+}
@@ -0,0 +1,211 @@
+package activitypub
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnauthorized    = errors.New("invalid or missing HTTP signature")
+	ErrInvalidActivity = errors.New("invalid activity")
+)
+
+// activity holds the properties of incoming activities.
+type activity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// objectID returns the ID of an object property, which is either a string or
+// an object.
+func objectID(raw json.RawMessage) string {
+	var id string
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return id
+	}
+	var obj struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(raw, &obj)
+	return obj.ID
+}
+
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	Endpoints struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+	PublicKey PublicKey `json:"publicKey"`
+
+	key *rsa.PublicKey
+}
+
+// Receive handles an activity posted to the inbox. The request must be
+// signed by the actor of the activity. Follow and Undo{Follow} activities
+// update the followers, other activities are ignored.
+func (f *Federation) Receive(r *http.Request, body []byte) error {
+	var act activity
+	if err := json.Unmarshal(body, &act); err != nil || act.Type == "" || act.Actor == "" {
+		return ErrInvalidActivity
+	}
+
+	actor, err := f.authenticate(r, body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	if actor.ID != act.Actor {
+		return fmt.Errorf("%w: signed by '%s' on behalf of '%s'", ErrUnauthorized, actor.ID, act.Actor)
+	}
+
+	switch act.Type {
+	case "Follow":
+		if objectID(act.Object) != f.Site.ActorID() {
+			return nil
+		}
+		f.follow(actor, &act, body)
+	case "Undo":
+		var inner activity
+		json.Unmarshal(act.Object, &inner)
+		followID := objectID(act.Object)
+		f.unfollow(actor.ID, followID, inner.Type == "Follow")
+	}
+	return nil
+}
+
+func (f *Federation) follow(actor *remoteActor, act *activity, body []byte) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	since := time.Now()
+	if old := f.followers[actor.ID]; old != nil {
+		since = old.Since
+	} else {
+		log.Printf("new follower: '%s'\n", actor.ID)
+	}
+	f.followers[actor.ID] = &Follower{
+		ID:          actor.ID,
+		Inbox:       actor.Inbox,
+		SharedInbox: actor.Endpoints.SharedInbox,
+		FollowID:    act.ID,
+		Since:       since,
+	}
+	f.saveFollowers()
+
+	sum := sha256.Sum256([]byte(act.ID))
+	f.enqueue(actor.Inbox, &Object{
+		Context: context,
+		ID:      f.Site.ActorID() + "#accept-" + hex.EncodeToString(sum[:8]),
+		Type:    "Accept",
+		Actor:   f.Site.ActorID(),
+		Object:  json.RawMessage(body),
+	})
+}
+
+// unfollow removes the follower if the undone activity is its Follow. Undo
+// activities referencing the Follow by ID are matched against the stored ID.
+func (f *Federation) unfollow(actorID, followID string, isFollow bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	fl := f.followers[actorID]
+	if fl == nil || !isFollow && (followID == "" || followID != fl.FollowID) {
+		return
+	}
+	delete(f.followers, actorID)
+	f.saveFollowers()
+	log.Printf("lost follower: '%s'\n", actorID)
+}
+
+// authenticate verifies the signature of the request and returns the actor
+// owning the key. The key is fetched again if the signature doesn't match the
+// cached one, in case it was rotated.
+func (f *Federation) authenticate(r *http.Request, body []byte) (*remoteActor, error) {
+	sig, err := parseSignature(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := sig.checkHeaders(r, body); err != nil {
+		return nil, err
+	}
+
+	f.mux.Lock()
+	actor := f.actors[sig.keyID]
+	f.mux.Unlock()
+	if actor != nil && sig.verify(r, actor.key) == nil {
+		return actor, nil
+	}
+
+	actor, err = f.fetchActor(sig.keyID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch key '%s': %v", sig.keyID, err)
+	}
+	if err := sig.verify(r, actor.key); err != nil {
+		return nil, err
+	}
+	f.mux.Lock()
+	f.actors[sig.keyID] = actor
+	f.mux.Unlock()
+	return actor, nil
+}
+
+// fetchActor fetches the actor document owning the key. The request is
+// signed, for servers which require authorized fetches.
+func (f *Federation) fetchActor(keyID string) (*remoteActor, error) {
+	u, err := url.Parse(keyID)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New("invalid key ID")
+	}
+	u.Fragment = ""
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentType+`, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`)
+	if err := sign(req, f.Site.KeyID(), f.key, nil); err != nil {
+		return nil, err
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDocumentSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var actor remoteActor
+	if err := json.Unmarshal(data, &actor); err != nil {
+		return nil, err
+	}
+	if actor.PublicKey.ID != keyID || actor.PublicKey.Owner != actor.ID {
+		return nil, errors.New("key not owned by actor")
+	}
+	// The document could claim any ID: the actor must be hosted with the key.
+	id, err := url.Parse(actor.ID)
+	if err != nil || !strings.EqualFold(id.Host, u.Host) || !strings.HasPrefix(actor.Inbox, "http") {
+		return nil, errors.New("invalid actor")
+	}
+	if actor.key, err = parsePublicKey(actor.PublicKey.PublicKeyPem); err != nil {
+		return nil, err
+	}
+	return &actor, nil
+}
@@ -0,0 +1,209 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const keyBits = 2048
+
+// maxClockSkew is the maximum difference between the Date header of a signed
+// request and the current time.
+const maxClockSkew = 12 * time.Hour
+
+var (
+	errNoSignature      = errors.New("missing signature")
+	errInvalidSignature = errors.New("invalid signature")
+)
+
+// loadKey reads the PEM encoded private key from the file, generating it if
+// it doesn't exist.
+func loadKey(fp string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(fp)
+	if os.IsNotExist(err) {
+		key, err := rsa.GenerateKey(rand.Reader, keyBits)
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})
+		if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(fp, data, 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid key file '%s'", fp)
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func encodePublicKey(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func parsePublicKey(s string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("invalid public key")
+	}
+	var key interface{}
+	var err error
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("unsupported public key type")
+	}
+	return rsaKey, nil
+}
+
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signingString builds the string covered by the signature, from the listed
+// headers of the request.
+func signingString(r *http.Request, headers []string) string {
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		var v string
+		switch h {
+		case "(request-target)":
+			v = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			v = r.Host
+			if v == "" {
+				v = r.URL.Host
+			}
+		default:
+			v = strings.Join(r.Header.Values(h), ", ")
+		}
+		lines[i] = h + ": " + v
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sign adds an HTTP signature to the request, as described in
+// https://tools.ietf.org/html/draft-cavage-http-signatures-12, covering the
+// target, host, date and, for requests with a body, its digest.
+func sign(r *http.Request, keyID string, key *rsa.PrivateKey, body []byte) error {
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		r.Header.Set("Digest", digest(body))
+		headers = append(headers, "digest")
+	}
+	sum := sha256.Sum256([]byte(signingString(r, headers)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+var reSignatureParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+type signature struct {
+	keyID   string
+	headers []string
+	sig     []byte
+}
+
+func parseSignature(r *http.Request) (*signature, error) {
+	h := r.Header.Get("Signature")
+	if h == "" {
+		return nil, errNoSignature
+	}
+	s := &signature{headers: []string{"date"}}
+	for _, m := range reSignatureParam.FindAllStringSubmatch(h, -1) {
+		switch m[1] {
+		case "keyId":
+			s.keyID = m[2]
+		case "headers":
+			s.headers = strings.Fields(strings.ToLower(m[2]))
+		case "signature":
+			sig, err := base64.StdEncoding.DecodeString(m[2])
+			if err != nil {
+				return nil, errInvalidSignature
+			}
+			s.sig = sig
+		}
+	}
+	if s.keyID == "" || s.sig == nil {
+		return nil, errInvalidSignature
+	}
+	return s, nil
+}
+
+// checkHeaders checks that the signature covers the request target, date
+// and body, and that they match the request.
+func (s *signature) checkHeaders(r *http.Request, body []byte) error {
+	covered := make(map[string]bool)
+	for _, h := range s.headers {
+		covered[h] = true
+	}
+	if !covered["(request-target)"] || !covered["date"] {
+		return fmt.Errorf("%w: request target and date must be signed", errInvalidSignature)
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("%w: invalid date", errInvalidSignature)
+	}
+	if skew := time.Since(date); skew > maxClockSkew || skew < -maxClockSkew {
+		return fmt.Errorf("%w: date out of range", errInvalidSignature)
+	}
+	if body != nil {
+		if !covered["digest"] {
+			return fmt.Errorf("%w: digest must be signed", errInvalidSignature)
+		}
+		if r.Header.Get("Digest") != digest(body) {
+			return fmt.Errorf("%w: digest mismatch", errInvalidSignature)
+		}
+	}
+	return nil
+}
+
+func (s *signature) verify(r *http.Request, key *rsa.PublicKey) error {
+	sum := sha256.Sum256([]byte(signingString(r, s.headers)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], s.sig); err != nil {
+		return errInvalidSignature
+	}
+	return nil
+}
@@ -1,10 +1,19 @@
 package app
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"path/filepath"
+	"presence/activitypub"
 	"presence/config"
 	"presence/model"
+	"presence/safehttp"
 	"presence/store"
+	"presence/webmention"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const AppName = "presence"
@@ -12,9 +21,12 @@ const AppName = "presence"
 var Version = "" // injected on build
 
 type App struct {
-	Config *config.Config
-	posts  *store.ArticleStore
-	pages  *store.ArticleStore
+	config      atomic.Value // *config.Config
+	posts       *store.ArticleStore
+	pages       *store.ArticleStore
+	webmentions *webmention.Receiver
+	sender      *webmention.Sender
+	federation  *activitypub.Federation
 }
 
 func New(config *config.Config) (*App, error) {
@@ -29,16 +41,33 @@ func New(config *config.Config) (*App, error) {
 	if err != nil {
 		return nil, fmt.Errorf("couldn't init pages: %s", err)
 	}
+	webmentions, err := webmention.NewReceiver(config.PostsDir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't init webmentions: %s", err)
+	}
 
 	app := &App{
-		Config: config,
-		posts:  posts,
-		pages:  pages,
+		posts:       posts,
+		pages:       pages,
+		webmentions: webmentions,
 	}
+	app.config.Store(config)
 
 	return app, nil
 }
 
+// Config returns the current configuration. It's shared by all the readers,
+// so it must not be modified once the server is running.
+func (a *App) Config() *config.Config {
+	return a.config.Load().(*config.Config)
+}
+
+// SetConfig replaces the configuration, e.g. after it's reloaded. Settings
+// read once at startup, such as the directories, aren't affected.
+func (a *App) SetConfig(c *config.Config) {
+	a.config.Store(c)
+}
+
 func (a *App) GetPost(slug string) *model.Article {
 	return a.posts.Get(slug)
 }
@@ -53,6 +82,22 @@ func (a *App) GetRecentPosts(offset, limit int) []*model.Article {
 	return a.posts.GetRecent(offset, limit)
 }
 
+// GetPostsByTag returns all the posts with the given tag, sorted by
+// publication time (newest first).
+func (a *App) GetPostsByTag(tag string) []*model.Article {
+	return a.posts.GetByTag(tag)
+}
+
+// GetTags returns the tags used in posts along with their post counts.
+func (a *App) GetTags() map[string]int {
+	return a.posts.Tags()
+}
+
+// SearchPosts returns the posts matching the query, most relevant first.
+func (a *App) SearchPosts(query string) []*store.SearchResult {
+	return a.posts.Search(query)
+}
+
 func (a *App) GetPage(slug string) *model.Article {
 	return a.pages.Get(slug)
 }
@@ -61,11 +106,180 @@ func (a *App) GetAllPages() []*model.Article {
 	return a.pages.GetAll()
 }
 
+// GetUnpublished returns the unpublished post or page with the given slug.
+func (a *App) GetUnpublished(slug string) *model.Article {
+	if article := a.posts.GetUnpublished(slug); article != nil {
+		return article
+	}
+	return a.pages.GetUnpublished(slug)
+}
+
+// LookupPost returns the post with the given slug, published or not.
+func (a *App) LookupPost(slug string) *model.Article {
+	return a.posts.Lookup(slug)
+}
+
+// CreatePost writes a new post file with the given slug and contents.
+func (a *App) CreatePost(slug string, contents []byte) (*model.Article, error) {
+	return a.posts.Create(slug, contents)
+}
+
+// UpdatePost replaces the contents of the post file.
+func (a *App) UpdatePost(slug string, contents []byte) (*model.Article, error) {
+	return a.posts.Update(slug, contents)
+}
+
+// DeletePost removes the post file.
+func (a *App) DeletePost(slug string) error {
+	return a.posts.Delete(slug)
+}
+
+// ReceiveWebmention queues the webmention for verification. The target must
+// be the URL of a published post.
+func (a *App) ReceiveWebmention(source, target string) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		!strings.EqualFold(u.Hostname(), a.Config().Host) {
+		return webmention.ErrInvalidTarget
+	}
+	slug := strings.Trim(u.Path, "/")
+	if a.posts.Get(slug) == nil {
+		return webmention.ErrInvalidTarget
+	}
+	return a.webmentions.Enqueue(source, target, slug)
+}
+
+// StartWebmentionSender starts sending webmentions for the links in
+// published posts whenever a post is published, updated or removed. Changes
+// made while the server wasn't running are sent now; on the first run, the
+// links of the existing posts are only recorded. The send log is kept in
+// data_dir, so posts are only handled again after they change.
+func (a *App) StartWebmentionSender() error {
+	if a.Config().DataDir == "" {
+		return errors.New("data_dir must be set to send webmentions")
+	}
+	// The sources must be reachable for the targets to verify them.
+	if !safehttp.IsPublicHost(a.Config().Host) {
+		return fmt.Errorf("host '%s' isn't public", a.Config().Host)
+	}
+	sender, err := webmention.NewSender(filepath.Join(a.Config().DataDir, "webmentions-sent.json"))
+	if err != nil {
+		return err
+	}
+	a.sender = sender
+
+	a.posts.Subscribe(func(e store.Event) {
+		source := a.Config().BaseURL() + "/" + e.Article.Slug
+		switch e.Type {
+		case store.EventPublish:
+			sender.Notify(source, e.Article.BodyHTML)
+		case store.EventRemove:
+			sender.Notify(source, "")
+		}
+	})
+	contents := make(map[string]string)
+	for _, post := range a.posts.GetAll() {
+		contents[a.Config().BaseURL()+"/"+post.Slug] = post.BodyHTML
+	}
+	sender.Sync(contents)
+	return nil
+}
+
+// StartFederation makes the site an ActivityPub actor, and starts
+// delivering posts to its followers whenever a post is published, updated or
+// removed. Changes made while the server wasn't running are delivered now.
+// The followers and the delivery queue are kept in data_dir.
+func (a *App) StartFederation() error {
+	if a.Config().DataDir == "" {
+		return errors.New("data_dir must be set to federate")
+	}
+	if a.Config().FediverseUsername == "" {
+		return errors.New("fediverse_username must be set to federate")
+	}
+	federation, err := activitypub.New(filepath.Join(a.Config().DataDir, "activitypub"), activitypub.Site{
+		BaseURL:  a.Config().BaseURL(),
+		Username: a.Config().FediverseUsername,
+	})
+	if err != nil {
+		return err
+	}
+	a.federation = federation
+
+	a.posts.Subscribe(func(e store.Event) {
+		switch e.Type {
+		case store.EventPublish:
+			federation.Publish(e.Article)
+		case store.EventRemove:
+			federation.Unpublish(e.Article)
+		}
+	})
+	federation.Sync(a.posts.GetAll())
+	return nil
+}
+
+// Federation returns the ActivityPub actor, or nil if federation isn't
+// started.
+func (a *App) Federation() *activitypub.Federation {
+	return a.federation
+}
+
+// GetWebmentions returns the verified webmentions of the post.
+func (a *App) GetWebmentions(slug string) []*webmention.Mention {
+	return a.webmentions.Mentions(slug)
+}
+
+// Subscribe registers the function to be called on every change to the posts
+// and pages. See store.ArticleStore.Subscribe.
+func (a *App) Subscribe(f func(store.Event)) {
+	a.posts.Subscribe(f)
+	a.pages.Subscribe(f)
+}
+
+// SubscribePosts registers the function to be called on every change to the
+// posts.
+func (a *App) SubscribePosts(f func(store.Event)) {
+	a.posts.Subscribe(f)
+}
+
+// SubscribePages registers the function to be called on every change to the
+// pages.
+func (a *App) SubscribePages(f func(store.Event)) {
+	a.pages.Subscribe(f)
+}
+
+// SubscribeWebmentions registers the function to be called with the slug of
+// the post whenever its verified webmentions change.
+func (a *App) SubscribeWebmentions(f func(slug string)) {
+	a.webmentions.Subscribe(f)
+}
+
+// State returns a counter increased by every change to the posts, the pages
+// and the webmentions, and the time of the last change.
+func (a *App) State() (uint64, time.Time) {
+	var generation uint64
+	var modified time.Time
+	for _, state := range []func() (uint64, time.Time){a.posts.State, a.pages.State, a.webmentions.State} {
+		n, t := state()
+		generation += n
+		if t.After(modified) {
+			modified = t
+		}
+	}
+	return generation, modified
+}
+
 func (a *App) PostCount() int {
 	return a.posts.Len()
 }
 
 func (a *App) Close() {
+	if a.sender != nil {
+		a.sender.Close()
+	}
+	if a.federation != nil {
+		a.federation.Close()
+	}
+	a.webmentions.Close()
 	a.posts.Close()
 	a.pages.Close()
 }
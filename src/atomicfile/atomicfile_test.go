@@ -0,0 +1,74 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presence_test_atomicfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fp := filepath.Join(dir, "sub", "file.txt")
+	for _, contents := range []string{"first", "second"} {
+		if err := Write(fp, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != contents {
+			t.Errorf("want %q, got %q", contents, data)
+		}
+	}
+
+	info, err := os.Stat(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("want mode 0600, got %v", info.Mode().Perm())
+	}
+	// No temporary files should be left behind.
+	files, err := ioutil.ReadDir(filepath.Dir(fp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("want 1 file, got %d", len(files))
+	}
+}
+
+func TestJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presence_test_atomicfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fp := filepath.Join(dir, "file.json")
+
+	var got map[string]int
+	if ok, err := ReadJSON(fp, &got); ok || err != nil {
+		t.Errorf("missing file: want false and no error, got %v, %v", ok, err)
+	}
+
+	if err := WriteJSON(fp, map[string]int{"a": 1}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := ReadJSON(fp, &got); !ok || err != nil || got["a"] != 1 {
+		t.Errorf("want the written value, got %v, %v, %v", got, ok, err)
+	}
+
+	if err := Write(fp, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadJSON(fp, &got); err == nil {
+		t.Error("want error for invalid JSON")
+	}
+}
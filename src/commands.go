@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"presence/app"
+	"presence/config"
+	"presence/preview"
+	"presence/server"
+	"presence/theme"
+	"time"
+)
+
+const defaultPreviewDuration = 24 * time.Hour
+
+// parseServeArgs parses the arguments of the serve command, returning
+// whether dev mode is enabled.
+//
+// Usage: presence serve [--dev]
+func parseServeArgs(args []string) bool {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	dev := flags.Bool("dev", false, "reload pages in the browser on changes")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		die("usage: presence serve [--dev]")
+	}
+	return *dev
+}
+
+// cmdPreview prints a preview URL for an unpublished article.
+//
+// Usage: presence preview <slug> [duration]
+func cmdPreview(conf *config.Config, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		die("usage: presence preview <slug> [duration]")
+	}
+	if conf.PreviewSecret == "" {
+		die("preview_secret must be set to generate preview links")
+	}
+
+	slug := args[0]
+	duration := defaultPreviewDuration
+	if len(args) == 2 {
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			dief("invalid duration: %v", err)
+		}
+		duration = d
+	}
+
+	expires := time.Now().Add(duration)
+	token := preview.NewToken(conf.PreviewSecret, slug, expires)
+	fmt.Printf("%s/preview/%s?token=%s\n", conf.BaseURL(), slug, url.QueryEscape(token))
+	fmt.Printf("expires: %s\n", expires.Format(time.RFC1123))
+}
+
+const defaultBuildDir = "public"
+
+// cmdBuild exports the site as a collection of static files.
+//
+// Usage: presence build [dir]
+func cmdBuild(conf *config.Config, args []string) {
+	if len(args) > 1 {
+		die("usage: presence build [dir]")
+	}
+	dir := defaultBuildDir
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	a, err := app.New(conf)
+	if err != nil {
+		die(err)
+	}
+	defer a.Close()
+
+	s, err := server.New(a)
+	if err != nil {
+		die(err)
+	}
+
+	if err := s.Export(dir); err != nil {
+		dief("couldn't build site: %v", err)
+	}
+	fmt.Printf("-> %s\n", dir)
+}
+
+// cmdTheme manages the default theme embedded in the binary.
+//
+// Usage: presence theme eject
+func cmdTheme(conf *config.Config, args []string) {
+	if len(args) != 1 || args[0] != "eject" {
+		die("usage: presence theme eject")
+	}
+	if conf.TemplatesDir == "" || conf.StaticDir == "" {
+		die("templates_dir and static_dir must be set to eject the theme")
+	}
+
+	for _, dir := range []struct {
+		fsys fs.FS
+		path string
+	}{
+		{theme.Templates, conf.TemplatesDir},
+		{theme.Static, conf.StaticDir},
+	} {
+		copied, err := theme.Eject(dir.fsys, dir.path)
+		if err != nil {
+			dief("couldn't eject theme: %v", err)
+		}
+		for _, fp := range copied {
+			fmt.Printf("-> %s\n", fp)
+		}
+	}
+}
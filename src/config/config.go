@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,22 +15,43 @@ type SiteConfig struct {
 	Description       string
 	MaxEntriesPerPage uint
 	DateFormat        string
+	FeedItems         uint
+	FeedFullContent   bool
+	RobotsDisallow    []string
+	FediverseUsername string
 }
 
 type ServerConfig struct {
-	Host         string
-	Port         uint
-	PortTLS      uint
-	ForceTLS     bool
-	TLSKey       string
-	TLSCert      string
-	StaticDir    string
-	PostsDir     string
-	PagesDir     string
-	TemplatesDir string
-	ErrorLog     string
-	AccessLog    string
-	ProxyCount   uint
+	Host          string
+	Port          uint
+	PortTLS       uint
+	ForceTLS      bool
+	TLSKey        string
+	TLSCert       string
+	ACME          bool
+	ACMEDirectory string
+	ACMEEmail     string
+	ACMECacheDir  string
+	ACMERootCA    string
+	GeminiPort    uint
+	GopherPort    uint
+	StaticDir     string
+	MediaDir      string
+	PostsDir      string
+	PagesDir      string
+	TemplatesDir  string
+	DataDir       string
+	ErrorLog      string
+	AccessLog     string
+	ProxyCount    uint
+	CacheControl  string
+	PreviewSecret string
+	APITokens     []string
+
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+
+	SendWebmentions bool
 }
 
 type Config struct {
@@ -37,6 +59,22 @@ type Config struct {
 	*ServerConfig
 }
 
+// BaseURL returns the public URL of the site, without the trailing slash.
+func (c *Config) BaseURL() string {
+	if c.PortTLS != 0 {
+		var port string
+		if c.PortTLS != 443 {
+			port = fmt.Sprintf(":%d", c.PortTLS)
+		}
+		return "https://" + c.Host + port
+	}
+	var port string
+	if c.Port != 80 {
+		port = fmt.Sprintf(":%d", c.Port)
+	}
+	return "http://" + c.Host + port
+}
+
 func expandPath(path, home, cwd string) string {
 	if strings.HasPrefix(path, "~/") {
 		return filepath.Join(home, path[2:])
@@ -53,17 +91,36 @@ func LoadConfig(paths ...string) (*Config, error) {
 	viper.SetDefault("server.force_tls", false)
 	viper.SetDefault("server.tls_key", "")
 	viper.SetDefault("server.tls_cert", "")
+	viper.SetDefault("server.acme", false)
+	viper.SetDefault("server.acme_directory", "https://acme-v02.api.letsencrypt.org/directory")
+	viper.SetDefault("server.acme_email", "")
+	viper.SetDefault("server.acme_cache_dir", "")
+	viper.SetDefault("server.acme_root_ca", "")
+	viper.SetDefault("server.gemini_port", 0)
+	viper.SetDefault("server.gopher_port", 0)
+	viper.SetDefault("server.cache_control", "no-cache")
 	viper.SetDefault("server.static_dir", "")
+	viper.SetDefault("server.media_dir", "")
 	viper.SetDefault("server.posts_dir", "")
 	viper.SetDefault("server.pages_dir", "")
 	viper.SetDefault("server.templates_dir", "")
+	viper.SetDefault("server.data_dir", "")
+	viper.SetDefault("server.send_webmentions", false)
 	viper.SetDefault("server.error_log", "")
 	viper.SetDefault("server.access_log", "")
+	viper.SetDefault("server.preview_secret", "")
+	viper.SetDefault("server.api_tokens", []string{})
+	viper.SetDefault("server.authorization_endpoint", "")
+	viper.SetDefault("server.token_endpoint", "")
 	viper.SetDefault("site.title", "My Blog")
 	viper.SetDefault("site.author", "John Doe")
 	viper.SetDefault("site.description", "John Doe's personal blog")
 	viper.SetDefault("site.max_entries_per_page", 10)
 	viper.SetDefault("site.date_format", "%F")
+	viper.SetDefault("site.feed_items", 25)
+	viper.SetDefault("site.feed_full_content", true)
+	viper.SetDefault("site.robots_disallow", []string{})
+	viper.SetDefault("site.fediverse_username", "blog")
 
 	for _, p := range paths {
 		viper.AddConfigPath(p)
@@ -91,21 +148,42 @@ func LoadConfig(paths ...string) (*Config, error) {
 			Description:       viper.GetString("site.description"),
 			MaxEntriesPerPage: viper.GetUint("site.max_entries_per_page"),
 			DateFormat:        viper.GetString("site.date_format"),
+			FeedItems:         viper.GetUint("site.feed_items"),
+			FeedFullContent:   viper.GetBool("site.feed_full_content"),
+			RobotsDisallow:    viper.GetStringSlice("site.robots_disallow"),
+			FediverseUsername: viper.GetString("site.fediverse_username"),
 		},
 		&ServerConfig{
-			Host:         viper.GetString("server.host"),
-			Port:         viper.GetUint("server.port"),
-			PortTLS:      viper.GetUint("server.port_tls"),
-			ForceTLS:     viper.GetBool("server.force_tls"),
-			TLSKey:       expandPath(viper.GetString("server.tls_key"), home, cwd),
-			TLSCert:      expandPath(viper.GetString("server.tls_cert"), home, cwd),
-			StaticDir:    expandPath(viper.GetString("server.static_dir"), home, cwd),
-			PostsDir:     expandPath(viper.GetString("server.posts_dir"), home, cwd),
-			PagesDir:     expandPath(viper.GetString("server.pages_dir"), home, cwd),
-			TemplatesDir: expandPath(viper.GetString("server.templates_dir"), home, cwd),
-			AccessLog:    expandPath(viper.GetString("server.access_log"), home, cwd),
-			ErrorLog:     expandPath(viper.GetString("server.error_log"), home, cwd),
-			ProxyCount:   viper.GetUint("server.proxy_count"),
+			Host:          viper.GetString("server.host"),
+			Port:          viper.GetUint("server.port"),
+			PortTLS:       viper.GetUint("server.port_tls"),
+			ForceTLS:      viper.GetBool("server.force_tls"),
+			TLSKey:        expandPath(viper.GetString("server.tls_key"), home, cwd),
+			TLSCert:       expandPath(viper.GetString("server.tls_cert"), home, cwd),
+			ACME:          viper.GetBool("server.acme"),
+			ACMEDirectory: viper.GetString("server.acme_directory"),
+			ACMEEmail:     viper.GetString("server.acme_email"),
+			ACMECacheDir:  expandPath(viper.GetString("server.acme_cache_dir"), home, cwd),
+			ACMERootCA:    expandPath(viper.GetString("server.acme_root_ca"), home, cwd),
+			GeminiPort:    viper.GetUint("server.gemini_port"),
+			GopherPort:    viper.GetUint("server.gopher_port"),
+			StaticDir:     expandPath(viper.GetString("server.static_dir"), home, cwd),
+			MediaDir:      expandPath(viper.GetString("server.media_dir"), home, cwd),
+			PostsDir:      expandPath(viper.GetString("server.posts_dir"), home, cwd),
+			PagesDir:      expandPath(viper.GetString("server.pages_dir"), home, cwd),
+			TemplatesDir:  expandPath(viper.GetString("server.templates_dir"), home, cwd),
+			DataDir:       expandPath(viper.GetString("server.data_dir"), home, cwd),
+			AccessLog:     expandPath(viper.GetString("server.access_log"), home, cwd),
+			ErrorLog:      expandPath(viper.GetString("server.error_log"), home, cwd),
+			ProxyCount:    viper.GetUint("server.proxy_count"),
+			CacheControl:  viper.GetString("server.cache_control"),
+			PreviewSecret: viper.GetString("server.preview_secret"),
+			APITokens:     viper.GetStringSlice("server.api_tokens"),
+
+			AuthorizationEndpoint: viper.GetString("server.authorization_endpoint"),
+			TokenEndpoint:         viper.GetString("server.token_endpoint"),
+
+			SendWebmentions: viper.GetBool("server.send_webmentions"),
 		},
 	}
 
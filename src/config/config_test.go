@@ -33,6 +33,10 @@ site:
     author:               "%s"
     max_entries_per_page: %d
     date_format:          "%s"
+    feed_items:           %d
+    feed_full_content:    %v
+    robots_disallow:      ["%s"]
+    fediverse_username:   "%s"
 server:
     host:          "%s"
     port:          %d
@@ -40,13 +44,28 @@ server:
     force_tls:     %v
     tls_key:       "%s"
     tls_cert:      "%s"
+    acme:          %v
+    acme_directory: "%s"
+    acme_email:    "%s"
+    acme_cache_dir: "%s"
+    acme_root_ca:  "%s"
+    gemini_port:   %d
+    gopher_port:   %d
     static_dir:    "%s"
+    media_dir:     "%s"
     posts_dir:     "%s"
     pages_dir:     "%s"
     templates_dir: "%s"
+    data_dir:      "%s"
     access_log:    "%s"
     error_log:     "%s"
     proxy_count:   %d
+    cache_control: "%s"
+    preview_secret: "%s"
+    api_tokens:     ["%s"]
+    authorization_endpoint: "%s"
+    token_endpoint: "%s"
+    send_webmentions: %v
 `
 
 func yamlFromConfig(c *Config) string {
@@ -57,19 +76,38 @@ func yamlFromConfig(c *Config) string {
 		c.SiteConfig.Author,
 		c.SiteConfig.MaxEntriesPerPage,
 		c.SiteConfig.DateFormat,
+		c.SiteConfig.FeedItems,
+		c.SiteConfig.FeedFullContent,
+		strings.Join(c.SiteConfig.RobotsDisallow, `", "`),
+		c.SiteConfig.FediverseUsername,
 		c.ServerConfig.Host,
 		c.ServerConfig.Port,
 		c.ServerConfig.PortTLS,
 		c.ServerConfig.ForceTLS,
 		c.ServerConfig.TLSKey,
 		c.ServerConfig.TLSCert,
+		c.ServerConfig.ACME,
+		c.ServerConfig.ACMEDirectory,
+		c.ServerConfig.ACMEEmail,
+		c.ServerConfig.ACMECacheDir,
+		c.ServerConfig.ACMERootCA,
+		c.ServerConfig.GeminiPort,
+		c.ServerConfig.GopherPort,
 		c.ServerConfig.StaticDir,
+		c.ServerConfig.MediaDir,
 		c.ServerConfig.PostsDir,
 		c.ServerConfig.PagesDir,
 		c.ServerConfig.TemplatesDir,
+		c.ServerConfig.DataDir,
 		c.ServerConfig.AccessLog,
 		c.ServerConfig.ErrorLog,
 		c.ServerConfig.ProxyCount,
+		c.ServerConfig.CacheControl,
+		c.ServerConfig.PreviewSecret,
+		strings.Join(c.ServerConfig.APITokens, `", "`),
+		c.ServerConfig.AuthorizationEndpoint,
+		c.ServerConfig.TokenEndpoint,
+		c.ServerConfig.SendWebmentions,
 	)
 }
 
@@ -84,21 +122,42 @@ func TestLoadConfig(t *testing.T) {
 			Author:            "Johnny",
 			MaxEntriesPerPage: 5,
 			DateFormat:        "%F",
+			FeedItems:         50,
+			FeedFullContent:   false,
+			RobotsDisallow:    []string{"/private/", "/tmp/"},
+			FediverseUsername: "johnny",
 		},
 		&ServerConfig{
-			Host:         "localhost",
-			Port:         80,
-			PortTLS:      443,
-			ForceTLS:     true,
-			TLSKey:       filepath.Join("path", "to", "key.pem"),
-			TLSCert:      filepath.Join("path", "to", "cert.pem"),
-			StaticDir:    filepath.Join("path", "to", "static"),
-			PostsDir:     filepath.Join("path", "to", "posts"),
-			PagesDir:     filepath.Join("path", "to", "pages"),
-			TemplatesDir: filepath.Join("path", "to", "templates"),
-			AccessLog:    filepath.Join("path", "to", "access.log"),
-			ErrorLog:     filepath.Join("path", "to", "error.log"),
-			ProxyCount:   1,
+			Host:          "localhost",
+			Port:          80,
+			PortTLS:       443,
+			ForceTLS:      true,
+			TLSKey:        filepath.Join("path", "to", "key.pem"),
+			TLSCert:       filepath.Join("path", "to", "cert.pem"),
+			ACME:          true,
+			ACMEDirectory: "https://localhost:14000/dir",
+			ACMEEmail:     "johnny@example.org",
+			ACMECacheDir:  filepath.Join("path", "to", "acme"),
+			ACMERootCA:    filepath.Join("path", "to", "pebble.minica.pem"),
+			GeminiPort:    1965,
+			GopherPort:    70,
+			StaticDir:     filepath.Join("path", "to", "static"),
+			MediaDir:      filepath.Join("path", "to", "media"),
+			PostsDir:      filepath.Join("path", "to", "posts"),
+			PagesDir:      filepath.Join("path", "to", "pages"),
+			TemplatesDir:  filepath.Join("path", "to", "templates"),
+			DataDir:       filepath.Join("path", "to", "data"),
+			AccessLog:     filepath.Join("path", "to", "access.log"),
+			ErrorLog:      filepath.Join("path", "to", "error.log"),
+			ProxyCount:    1,
+			CacheControl:  "public, max-age=60",
+			PreviewSecret: "secret",
+			APITokens:     []string{"token1", "token2"},
+
+			AuthorizationEndpoint: "https://indieauth.example/auth",
+			TokenEndpoint:         "https://indieauth.example/token",
+
+			SendWebmentions: true,
 		},
 	}
 
@@ -117,3 +176,47 @@ func TestLoadConfig(t *testing.T) {
 		t.Fatalf("config mismatch (-want +got):\n\n%s\n", diff)
 	}
 }
+
+func TestSettingName(t *testing.T) {
+	tests := map[string]string{
+		"Title":             "title",
+		"MaxEntriesPerPage": "max_entries_per_page",
+		"PortTLS":           "port_tls",
+		"TLSKey":            "tls_key",
+		"APITokens":         "api_tokens",
+	}
+	for field, want := range tests {
+		if got := settingName(field); got != want {
+			t.Errorf("%s: want %q, got %q", field, want, got)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	old := &Config{
+		&SiteConfig{Title: "Old", MaxEntriesPerPage: 10},
+		&ServerConfig{Port: 80, PostsDir: "posts", TLSCert: "old.pem"},
+	}
+	new := &Config{
+		&SiteConfig{Title: "New", MaxEntriesPerPage: 10},
+		&ServerConfig{Port: 8080, PostsDir: "other", TLSCert: "new.pem"},
+	}
+
+	got, applied, restart := Merge(old, new)
+	want := &Config{
+		&SiteConfig{Title: "New", MaxEntriesPerPage: 10},
+		&ServerConfig{Port: 80, PostsDir: "posts", TLSCert: "new.pem"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("config mismatch (-want +got):\n\n%s\n", diff)
+	}
+	if diff := cmp.Diff([]string{"site.title", "server.tls_cert"}, applied); diff != "" {
+		t.Errorf("applied settings mismatch (-want +got):\n\n%s\n", diff)
+	}
+	if diff := cmp.Diff([]string{"server.port", "server.posts_dir"}, restart); diff != "" {
+		t.Errorf("restart settings mismatch (-want +got):\n\n%s\n", diff)
+	}
+	if old.Title != "Old" {
+		t.Error("old config modified")
+	}
+}
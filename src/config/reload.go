@@ -0,0 +1,75 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// restartSettings are the settings which only take effect after a restart.
+var restartSettings = map[string]bool{
+	"site.fediverse_username": true,
+	"server.host":             true,
+	"server.port":             true,
+	"server.port_tls":         true,
+	"server.force_tls":        true,
+	"server.acme":             true,
+	"server.acme_directory":   true,
+	"server.acme_email":       true,
+	"server.acme_cache_dir":   true,
+	"server.acme_root_ca":     true,
+	"server.gemini_port":      true,
+	"server.gopher_port":      true,
+	"server.static_dir":       true,
+	"server.media_dir":        true,
+	"server.posts_dir":        true,
+	"server.pages_dir":        true,
+	"server.templates_dir":    true,
+	"server.data_dir":         true,
+	"server.send_webmentions": true,
+}
+
+// Merge returns the configuration to use after reloading: a copy of old with
+// the settings which can change while the server is running taken from new.
+// It also returns the names of the changed settings which were applied, and
+// of those which require a restart.
+func Merge(old, new *Config) (*Config, []string, []string) {
+	site := *old.SiteConfig
+	server := *old.ServerConfig
+	var applied, restart []string
+	merge := func(prefix string, dst, src reflect.Value) {
+		for i := 0; i < dst.NumField(); i++ {
+			name := prefix + settingName(dst.Type().Field(i).Name)
+			if reflect.DeepEqual(dst.Field(i).Interface(), src.Field(i).Interface()) {
+				continue
+			}
+			if restartSettings[name] {
+				restart = append(restart, name)
+				continue
+			}
+			dst.Field(i).Set(src.Field(i))
+			applied = append(applied, name)
+		}
+	}
+	merge("site.", reflect.ValueOf(&site).Elem(), reflect.ValueOf(new.SiteConfig).Elem())
+	merge("server.", reflect.ValueOf(&server).Elem(), reflect.ValueOf(new.ServerConfig).Elem())
+	return &Config{&site, &server}, applied, restart
+}
+
+// settingName returns the name of the setting in the config file for the
+// field name, e.g. "port_tls" for "PortTLS".
+func settingName(field string) string {
+	runes := []rune(field)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
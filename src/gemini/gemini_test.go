@@ -0,0 +1,156 @@
+package gemini
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGemtext(t *testing.T) {
+	source := "Read [the docs](/docs) and *more* at https://example.org.\n" +
+		"\n" +
+		"![A cat](/cat.jpg)\n" +
+		"\n" +
+		"#### Deep heading\n" +
+		"\n" +
+		"- one\n" +
+		"- two [link](/two)\n" +
+		"  1. nested\n" +
+		"\n" +
+		"> quoted\n" +
+		"> text\n" +
+		"\n" +
+		"```go\n" +
+		"func main() {}\n" +
+		"```\n" +
+		"\n" +
+		"<div>html</div>\n" +
+		"\n" +
+		"| a | bb |\n" +
+		"|---|----|\n" +
+		"| ccc | d |\n"
+
+	want := "Read the docs and more at https://example.org.\n" +
+		"\n" +
+		"=> gemini://example.org/docs the docs\n" +
+		"=> https://example.org\n" +
+		"\n" +
+		"=> gemini://example.org/cat.jpg A cat\n" +
+		"\n" +
+		"### Deep heading\n" +
+		"\n" +
+		"* one\n" +
+		"* two link\n" +
+		"1. nested\n" +
+		"\n" +
+		"=> gemini://example.org/two link\n" +
+		"\n" +
+		"> quoted text\n" +
+		"\n" +
+		"```go\n" +
+		"func main() {}\n" +
+		"```\n" +
+		"\n" +
+		"```\n" +
+		"a   | bb\n" +
+		"ccc | d\n" +
+		"```\n"
+
+	rewrite := func(dest string) string {
+		if strings.HasPrefix(dest, "/") {
+			return "gemini://example.org" + dest
+		}
+		return dest
+	}
+	if got := string(Gemtext([]byte(source), rewrite)); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := &Server{
+		Addr:      "127.0.0.1:0",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+		Handler: func(r *Request) *Response {
+			if r.URL.Path == "/hello" {
+				return Document([]byte("# Hello\n"))
+			}
+			return Error(StatusNotFound, "not found")
+		},
+	}
+	errch := make(chan error, 1)
+	go func() { errch <- s.ListenAndServe() }()
+
+	var addr string
+	for deadline := time.Now().Add(5 * time.Second); addr == ""; {
+		if a := s.srv.Addr(); a != nil {
+			addr = a.String()
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		request, want string
+	}{
+		{"gemini://localhost/hello\r\n", "20 text/gemini; charset=utf-8\r\n# Hello\n"},
+		{"gemini://localhost/missing\r\n", "51 not found\r\n"},
+		{"gemini://localhost\r\n", "31 gemini://localhost/\r\n"},
+		{"https://localhost/\r\n", "53 proxy request refused\r\n"},
+		{"/hello\r\n", "59 bad request\r\n"},
+		{"gemini://localhost/" + strings.Repeat("a", maxRequestSize) + "\r\n", "59 bad request\r\n"},
+	}
+	for _, tt := range tests {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(tt.request))
+		b, err := ioutil.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%q: want %q, got %q", tt.request, tt.want, b)
+		}
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errch; err != ErrServerClosed {
+		t.Errorf("want ErrServerClosed, got %v", err)
+	}
+}
+
+// testCertificate returns a self-signed certificate for localhost.
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,239 @@
+package gemini
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Linkify,
+		extension.Strikethrough,
+		extension.Table,
+	),
+)
+
+// Gemtext converts the Markdown source to gemtext. Gemtext has no inline
+// links, so the links and images of each block are listed after it as link
+// lines. Destinations are passed through rewrite, if it isn't nil, e.g. to
+// point links to files which aren't served over Gemini to the website.
+func Gemtext(source []byte, rewrite func(dest string) string) []byte {
+	c := &converter{source: source, rewrite: rewrite}
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	var blocks []string
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		if lines := c.block(n); len(lines) > 0 {
+			blocks = append(blocks, strings.Join(lines, "\n"))
+		}
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(blocks, "\n\n") + "\n")
+}
+
+type link struct {
+	dest, label string
+}
+
+type converter struct {
+	source  []byte
+	rewrite func(string) string
+	links   []link // links of the current block
+}
+
+// block returns the lines of the block node, followed by its links.
+func (c *converter) block(n ast.Node) []string {
+	lines := c.lines(n)
+	if len(c.links) == 0 {
+		return lines
+	}
+	// A paragraph only holding a link or an image is replaced by it.
+	if len(c.links) == 1 && len(lines) == 1 && lines[0] == c.links[0].label {
+		lines = nil
+	} else if len(lines) > 0 {
+		lines = append(lines, "")
+	}
+	for _, l := range c.links {
+		lines = append(lines, linkLine(l))
+	}
+	c.links = nil
+	return lines
+}
+
+func linkLine(l link) string {
+	if l.label == "" || l.label == l.dest {
+		return "=> " + l.dest
+	}
+	return "=> " + l.dest + " " + l.label
+}
+
+func (c *converter) lines(n ast.Node) []string {
+	switch n := n.(type) {
+	case *ast.Heading:
+		level := n.Level
+		if level > 3 {
+			level = 3
+		}
+		return []string{strings.Repeat("#", level) + " " + c.inline(n)}
+	case *ast.Paragraph, *ast.TextBlock:
+		return strings.Split(c.inline(n), "\n")
+	case *ast.List:
+		return c.list(n)
+	case *ast.Blockquote:
+		var lines []string
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			if len(lines) > 0 {
+				lines = append(lines, ">")
+			}
+			for _, line := range c.lines(child) {
+				lines = append(lines, strings.TrimSpace("> "+line))
+			}
+		}
+		return lines
+	case *ast.FencedCodeBlock:
+		return c.preformatted(n, string(n.Language(c.source)))
+	case *ast.CodeBlock:
+		return c.preformatted(n, "")
+	case *extast.Table:
+		return c.table(n)
+	}
+	// Thematic breaks and raw HTML have no gemtext equivalent.
+	return nil
+}
+
+// list returns the items of the list, nested lists being flattened, as
+// gemtext only has one level of unordered lists. Ordered lists keep their
+// numbers as text.
+func (c *converter) list(n *ast.List) []string {
+	var lines []string
+	number := n.Start
+	for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "* "
+		if n.IsOrdered() {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		first := true
+		for child := item.FirstChild(); child != nil; child = child.NextSibling() {
+			if l, ok := child.(*ast.List); ok {
+				lines = append(lines, c.list(l)...)
+				continue
+			}
+			for _, line := range c.lines(child) {
+				if first {
+					line = marker + line
+					first = false
+				}
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+func (c *converter) preformatted(n ast.Node, alt string) []string {
+	lines := []string{"```" + alt}
+	segments := n.Lines()
+	for i := 0; i < segments.Len(); i++ {
+		seg := segments.At(i)
+		lines = append(lines, strings.TrimRight(string(seg.Value(c.source)), "\n"))
+	}
+	return append(lines, "```")
+}
+
+// table returns the table as preformatted text, its columns aligned.
+func (c *converter) table(n *extast.Table) []string {
+	var rows [][]string
+	var widths []int
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for i, cell := 0, row.FirstChild(); cell != nil; i, cell = i+1, cell.NextSibling() {
+			s := c.inline(cell)
+			cells = append(cells, s)
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := len([]rune(s)); w > widths[i] {
+				widths[i] = w
+			}
+		}
+		rows = append(rows, cells)
+	}
+
+	lines := []string{"```"}
+	for _, cells := range rows {
+		var b strings.Builder
+		for i, s := range cells {
+			if i > 0 {
+				b.WriteString(" | ")
+			}
+			b.WriteString(s)
+			b.WriteString(strings.Repeat(" ", widths[i]-len([]rune(s))))
+		}
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+	return append(lines, "```")
+}
+
+// inline returns the text of the node's inline children, collecting their
+// links.
+func (c *converter) inline(n ast.Node) string {
+	var buf bytes.Buffer
+	c.writeInline(&buf, n)
+	return strings.TrimSpace(buf.String())
+}
+
+func (c *converter) writeInline(buf *bytes.Buffer, n ast.Node) {
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		switch child := child.(type) {
+		case *ast.Text:
+			buf.Write(child.Segment.Value(c.source))
+			if child.HardLineBreak() {
+				buf.WriteByte('\n')
+			} else if child.SoftLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			buf.Write(child.Value)
+		case *ast.CodeSpan:
+			buf.WriteByte('`')
+			c.writeInline(buf, child)
+			buf.WriteByte('`')
+		case *ast.Link:
+			start := buf.Len()
+			c.writeInline(buf, child)
+			c.addLink(string(child.Destination), buf.String()[start:])
+		case *ast.AutoLink:
+			u := string(child.URL(c.source))
+			if child.AutoLinkType == ast.AutoLinkEmail && !strings.HasPrefix(u, "mailto:") {
+				u = "mailto:" + u
+			}
+			buf.Write(child.Label(c.source))
+			c.addLink(u, string(child.Label(c.source)))
+		case *ast.Image:
+			var alt bytes.Buffer
+			c.writeInline(&alt, child)
+			buf.Write(alt.Bytes())
+			c.addLink(string(child.Destination), alt.String())
+		case *ast.RawHTML:
+			// Dropped, like HTML blocks.
+		default:
+			c.writeInline(buf, child)
+		}
+	}
+}
+
+func (c *converter) addLink(dest, label string) {
+	if c.rewrite != nil {
+		dest = c.rewrite(dest)
+	}
+	c.links = append(c.links, link{dest, strings.TrimSpace(label)})
+}
@@ -0,0 +1,126 @@
+// Package gemini implements a server for the Gemini protocol, and the
+// conversion of Markdown to gemtext, the protocol's document format.
+package gemini
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"presence/tcpserver"
+)
+
+// Status codes of the responses.
+const (
+	StatusSuccess             = 20
+	StatusRedirect            = 31
+	StatusTemporaryFailure    = 40
+	StatusNotFound            = 51
+	StatusProxyRequestRefused = 53
+	StatusBadRequest          = 59
+)
+
+// ContentType is the MIME type of gemtext documents.
+const ContentType = "text/gemini; charset=utf-8"
+
+const (
+	maxRequestSize = 1024 // the URL, without the CRLF
+	requestTimeout = 10 * time.Second
+)
+
+// ErrServerClosed is returned by ListenAndServe after Shutdown.
+var ErrServerClosed = tcpserver.ErrServerClosed
+
+// Request is a Gemini request, which is a single absolute URL.
+type Request struct {
+	URL        *url.URL
+	RemoteAddr string
+}
+
+// Response is the reply to a request. Meta is the MIME type of the body for
+// successful responses, the URL for redirects and a message for errors.
+type Response struct {
+	Status int
+	Meta   string
+	Body   []byte
+}
+
+// Document returns a successful response with the gemtext body.
+func Document(body []byte) *Response {
+	return &Response{StatusSuccess, ContentType, body}
+}
+
+// Error returns a response with the status and the message, which has no
+// body.
+func Error(status int, msg string) *Response {
+	return &Response{Status: status, Meta: msg}
+}
+
+// Handler responds to requests.
+type Handler func(*Request) *Response
+
+// Server serves Gemini requests over TLS.
+type Server struct {
+	Addr      string
+	TLSConfig *tls.Config
+	Handler   Handler
+
+	srv tcpserver.Server
+}
+
+// ListenAndServe listens on Addr with TLSConfig and serves the requests
+// until Shutdown is called.
+func (s *Server) ListenAndServe() error {
+	return s.srv.Serve(func() (net.Listener, error) {
+		return tls.Listen("tcp", s.Addr, s.TLSConfig)
+	}, s.serve)
+}
+
+// Shutdown stops accepting connections, and waits for the requests being
+// served to be answered or for the context to expire.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	resp := s.respond(conn)
+	fmt.Fprintf(conn, "%d %s\r\n", resp.Status, resp.Meta)
+	if resp.Status == StatusSuccess {
+		conn.Write(resp.Body)
+	}
+}
+
+func (s *Server) respond(conn net.Conn) (resp *Response) {
+	r := bufio.NewReader(io.LimitReader(conn, maxRequestSize+2))
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasSuffix(line, "\r\n") {
+		return Error(StatusBadRequest, "bad request")
+	}
+	u, err := url.Parse(strings.TrimSuffix(line, "\r\n"))
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return Error(StatusBadRequest, "bad request")
+	}
+	if u.Scheme != "gemini" {
+		return Error(StatusProxyRequestRefused, "proxy request refused")
+	}
+	if u.Path == "" {
+		return &Response{Status: StatusRedirect, Meta: u.ResolveReference(&url.URL{Path: "/"}).String()}
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("gemini: panic serving %s: %v\n", u, err)
+			resp = Error(StatusTemporaryFailure, "internal server error")
+		}
+	}()
+	return s.Handler(&Request{URL: u, RemoteAddr: conn.RemoteAddr().String()})
+}
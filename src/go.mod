@@ -1,9 +1,10 @@
 module presence
 
-go 1.15
+go 1.16
 
 require (
 	github.com/alecthomas/chroma v0.8.2
+	github.com/andybalholm/brotli v1.0.4
 	github.com/fsnotify/fsnotify v1.4.7
 	github.com/google/go-cmp v0.3.0
 	github.com/gorilla/feeds v1.1.1
@@ -11,7 +12,11 @@ require (
 	github.com/gorilla/mux v1.7.3
 	github.com/lestrrat-go/strftime v1.0.3
 	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/pelletier/go-toml v1.2.0
 	github.com/spf13/viper v1.7.1
 	github.com/yuin/goldmark v1.2.1
 	github.com/yuin/goldmark-highlighting v0.0.0-20200307114337-60d527fdb691
+	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
+	gopkg.in/yaml.v2 v2.2.4
 )
@@ -0,0 +1,70 @@
+package gopher
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextFile(t *testing.T) {
+	got := string(TextFile("line\n.dot\n"))
+	if want := "line\r\n..dot\r\n.\r\n"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := &Server{
+		Addr: "127.0.0.1:0",
+		Handler: func(r *Request) []byte {
+			return Menu([]Item{{TypeText, "Post", r.Selector, "example.org", 70}})
+		},
+	}
+	errch := make(chan error, 1)
+	go func() { errch <- s.ListenAndServe() }()
+
+	var addr string
+	for deadline := time.Now().Add(5 * time.Second); addr == ""; {
+		if a := s.srv.Addr(); a != nil {
+			addr = a.String()
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		request, want string
+	}{
+		{"/post\r\n", "0Post\t/post\texample.org\t70\r\n.\r\n"},
+		{"/post\tsearch terms\r\n", "0Post\t/post\texample.org\t70\r\n.\r\n"},
+		{"\r\n", "0Post\t\texample.org\t70\r\n.\r\n"},
+		{strings.Repeat("a", maxSelectorSize+2), "3bad request\t\terror.host\t1\r\n.\r\n"},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(tt.request))
+		b, err := ioutil.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%q: want %q, got %q", tt.request, tt.want, b)
+		}
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errch; err != ErrServerClosed {
+		t.Errorf("want ErrServerClosed, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Package gopher implements a server for the Gopher protocol (RFC 1436).
+package gopher
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"presence/tcpserver"
+)
+
+// Item types used in menus.
+const (
+	TypeText  = '0'
+	TypeMenu  = '1'
+	TypeError = '3'
+	TypeHTML  = 'h'
+	TypeInfo  = 'i'
+)
+
+const (
+	maxSelectorSize = 1024
+	requestTimeout  = 10 * time.Second
+)
+
+// ErrServerClosed is returned by ListenAndServe after Shutdown.
+var ErrServerClosed = tcpserver.ErrServerClosed
+
+// Item is a line of a menu, linking to the selector on the server at Host
+// and Port.
+type Item struct {
+	Type     byte
+	Display  string
+	Selector string
+	Host     string
+	Port     uint
+}
+
+// Info returns a menu item only displaying the text.
+func Info(text string) Item {
+	return Item{Type: TypeInfo, Display: text, Host: "error.host", Port: 1}
+}
+
+// Menu encodes the items as a menu, also known as a gophermap.
+func Menu(items []Item) []byte {
+	var b strings.Builder
+	for _, it := range items {
+		display := strings.NewReplacer("\t", " ", "\r", "", "\n", " ").Replace(it.Display)
+		fmt.Fprintf(&b, "%c%s\t%s\t%s\t%d\r\n", it.Type, display, it.Selector, it.Host, it.Port)
+	}
+	b.WriteString(".\r\n")
+	return []byte(b.String())
+}
+
+// Error returns a menu holding the error message.
+func Error(msg string) []byte {
+	return Menu([]Item{{Type: TypeError, Display: msg, Host: "error.host", Port: 1}})
+}
+
+// TextFile encodes the text as a text file, with CRLF line endings, lines
+// starting with a period escaped, and the terminating period.
+func TextFile(text string) []byte {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if strings.HasPrefix(line, ".") {
+			b.WriteByte('.')
+		}
+		b.WriteString(strings.TrimRight(line, "\r"))
+		b.WriteString("\r\n")
+	}
+	b.WriteString(".\r\n")
+	return []byte(b.String())
+}
+
+// Request is a Gopher request for the selector. The search string, if any,
+// is dropped.
+type Request struct {
+	Selector   string
+	RemoteAddr string
+}
+
+// Handler returns the response to a request: a menu or a text file.
+type Handler func(*Request) []byte
+
+// Server serves Gopher requests.
+type Server struct {
+	Addr    string
+	Handler Handler
+
+	srv tcpserver.Server
+}
+
+// ListenAndServe listens on Addr and serves the selectors until Shutdown is
+// called.
+func (s *Server) ListenAndServe() error {
+	return s.srv.Serve(func() (net.Listener, error) {
+		return net.Listen("tcp", s.Addr)
+	}, s.serve)
+}
+
+// Shutdown stops accepting connections, and waits for the responses being
+// sent to be done or for the context to expire.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+	conn.Write(s.respond(conn))
+}
+
+func (s *Server) respond(conn net.Conn) (resp []byte) {
+	r := bufio.NewReader(io.LimitReader(conn, maxSelectorSize+2))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return Error("bad request")
+	}
+	selector := strings.TrimRight(line, "\r\n")
+	if i := strings.IndexByte(selector, '\t'); i >= 0 {
+		selector = selector[:i]
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("gopher: panic serving %q: %v\n", selector, err)
+			resp = Error("internal server error")
+		}
+	}()
+	return s.Handler(&Request{Selector: selector, RemoteAddr: conn.RemoteAddr().String()})
+}
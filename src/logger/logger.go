@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 const logFlags = log.Ldate | log.Ltime
@@ -12,40 +13,100 @@ const logFlags = log.Ldate | log.Ltime
 // Logger wraps log.Logger, adding optional file-logging capability.
 type Logger struct {
 	*log.Logger
-	file *os.File
+	file    *os.File
+	console bool
+	mux     sync.Mutex
 }
 
 func NewLogger() *Logger {
-	return &Logger{Logger: log.New(os.Stdin, "", logFlags)}
+	return &Logger{Logger: log.New(os.Stdin, "", logFlags), console: true}
 }
 
 func NewFileLogger(filename string, printToConsole bool) (*Logger, error) {
-	dir, _ := filepath.Split(filename)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	logger := &Logger{
+		Logger:  log.New(os.Stdin, "", logFlags),
+		console: printToConsole,
+	}
+	if err := logger.SetFile(filename); err != nil {
 		return nil, err
 	}
-	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-	if err != nil {
+	return logger, nil
+}
+
+func openFile(filename string) (*os.File, error) {
+	dir, _ := filepath.Split(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
+	return os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
 
-	var writer io.Writer
-	if printToConsole {
-		writer = io.MultiWriter(os.Stdin, f)
-	} else {
-		writer = f
+// SetFile switches logging to the file, closing the previous one. Calling it
+// with the same filename reopens the file, e.g. after it was rotated. An
+// empty filename stops logging to a file.
+func (l *Logger) SetFile(filename string) error {
+	var f *os.File
+	var writer io.Writer = os.Stdin
+	if filename != "" {
+		var err error
+		if f, err = openFile(filename); err != nil {
+			return err
+		}
+		if l.console {
+			writer = io.MultiWriter(os.Stdin, f)
+		} else {
+			writer = f
+		}
 	}
+	l.SetOutput(writer)
 
-	logger := &Logger{
-		Logger: log.New(writer, "", logFlags),
-		file:   f,
+	l.mux.Lock()
+	old := l.file
+	l.file = f
+	l.mux.Unlock()
+	if old != nil {
+		old.Close()
 	}
-	return logger, nil
+	return nil
 }
 
 func (l *Logger) Close() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
 	if l.file != nil {
 		l.file.Close()
 		l.file = nil
 	}
 }
+
+// std holds the file the standard logger writes to, if any.
+var std struct {
+	file *os.File
+	mux  sync.Mutex
+}
+
+// SetStandardFile makes the standard logger write to the file as well as to
+// stderr, closing the previous file. Calling it with the same filename reopens
+// the file, e.g. after it was rotated. An empty filename stops logging to a
+// file.
+func SetStandardFile(filename string) error {
+	var f *os.File
+	var writer io.Writer = os.Stderr
+	if filename != "" {
+		var err error
+		if f, err = openFile(filename); err != nil {
+			return err
+		}
+		writer = io.MultiWriter(os.Stderr, f)
+	}
+	log.SetOutput(writer)
+
+	std.mux.Lock()
+	old := std.file
+	std.file = f
+	std.mux.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
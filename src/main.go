@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -25,16 +26,52 @@ func main() {
 		dief("couldn't load config: %v", err)
 	}
 
+	dev := false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			dev = parseServeArgs(os.Args[2:])
+		case "preview":
+			cmdPreview(conf, os.Args[2:])
+			return
+		case "build":
+			cmdBuild(conf, os.Args[2:])
+			return
+		case "theme":
+			cmdTheme(conf, os.Args[2:])
+			return
+		default:
+			dief("unknown command: %s", os.Args[1])
+		}
+	}
+
 	a, err := app.New(conf)
 	if err != nil {
 		die(err)
 	}
 	defer a.Close()
 
+	// Drafts edited in dev mode shouldn't reach other sites.
+	if !dev {
+		if a.Config().SendWebmentions {
+			if err := a.StartWebmentionSender(); err != nil {
+				log.Printf("warning: %v - not sending webmentions\n", err)
+			}
+		}
+		if err := a.StartFederation(); err != nil {
+			log.Printf("warning: %v - not federating\n", err)
+		}
+	}
+
 	s, err := server.New(a)
 	if err != nil {
 		die(err)
 	}
+	if dev {
+		if err := s.EnableDevMode(); err != nil {
+			dief("couldn't enable dev mode: %v", err)
+		}
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -44,6 +81,20 @@ func main() {
 		s.Close()
 	}()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("reloading config...")
+			conf, err := config.LoadConfig(homeconf, "/etc/presence")
+			if err != nil {
+				log.Printf("couldn't reload config: %v\n", err)
+				continue
+			}
+			s.ReloadConfig(conf)
+		}
+	}()
+
 	if err := s.Run(); err != nil {
 		die(err)
 	}
@@ -6,10 +6,20 @@ import (
 
 // Article represents a blog post or a page.
 type Article struct {
-	Slug     string
-	Title    string
-	PubTime  *time.Time
-	Filename string
-	BodyRaw  []byte
-	BodyHTML string
+	Slug         string
+	Title        string
+	Description  string
+	Author       string
+	PubTime      *time.Time
+	Updated      *time.Time
+	Tags         []string
+	Draft        bool
+	Template     string
+	Aliases      []string
+	Params       map[string]interface{} // custom front matter fields
+	Filename     string
+	ModTime      time.Time // last modification time of the file
+	BodyRaw      []byte
+	BodyMarkdown []byte // body without the front matter and the title
+	BodyHTML     string
 }
@@ -0,0 +1,267 @@
+// Package plaintext renders Markdown as plain text, for the clients which
+// don't display HTML.
+package plaintext
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Linkify,
+		extension.Strikethrough,
+		extension.Table,
+	),
+)
+
+// Render renders the Markdown source as plain text wrapped at width. Links are
+// numbered in the text, and their destinations listed at the end. They're
+// passed through rewrite first, if it isn't nil.
+func Render(source []byte, width int, rewrite func(dest string) string) string {
+	c := &converter{source: source, rewrite: rewrite}
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	blocks := c.blocks(doc, width)
+	if len(c.links) > 0 {
+		refs := make([]string, len(c.links))
+		for i, dest := range c.links {
+			refs[i] = fmt.Sprintf("[%d] %s", i+1, dest)
+		}
+		blocks = append(blocks, refs)
+	}
+
+	var b strings.Builder
+	for i, lines := range blocks {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		for _, line := range lines {
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+type converter struct {
+	source  []byte
+	rewrite func(string) string
+	links   []string
+}
+
+// blocks returns the lines of each block child of the node.
+func (c *converter) blocks(n ast.Node, width int) [][]string {
+	var blocks [][]string
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		if lines := c.block(child, width); len(lines) > 0 {
+			blocks = append(blocks, lines)
+		}
+	}
+	return blocks
+}
+
+func (c *converter) block(n ast.Node, width int) []string {
+	switch n := n.(type) {
+	case *ast.Heading:
+		title := c.inline(n)
+		underline := "-"
+		if n.Level <= 2 {
+			underline = "="
+		}
+		return []string{title, strings.Repeat(underline, utf8.RuneCountInString(title))}
+	case *ast.Paragraph, *ast.TextBlock:
+		text := c.inline(n)
+		if text == "" {
+			return nil
+		}
+		var lines []string
+		for _, line := range strings.Split(text, "\n") {
+			lines = append(lines, wrap(line, width)...)
+		}
+		return lines
+	case *ast.List:
+		return c.list(n, width)
+	case *ast.Blockquote:
+		var lines []string
+		for i, block := range c.blocks(n, width-2) {
+			if i > 0 {
+				lines = append(lines, ">")
+			}
+			for _, line := range block {
+				lines = append(lines, strings.TrimRight("> "+line, " "))
+			}
+		}
+		return lines
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		var lines []string
+		segments := n.Lines()
+		for i := 0; i < segments.Len(); i++ {
+			seg := segments.At(i)
+			line := strings.TrimRight(string(seg.Value(c.source)), "\n")
+			lines = append(lines, strings.TrimRight("    "+line, " "))
+		}
+		return lines
+	case *ast.ThematicBreak:
+		return []string{strings.Repeat("-", width)}
+	case *extast.Table:
+		return c.table(n)
+	}
+	// Raw HTML is dropped.
+	return nil
+}
+
+// list returns the items of the list, with their continuation lines and
+// nested lists indented.
+func (c *converter) list(n *ast.List, width int) []string {
+	var lines []string
+	number := n.Start
+	for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "* "
+		if n.IsOrdered() {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		indent := strings.Repeat(" ", len(marker))
+		for i, block := range c.blocks(item, width-len(marker)) {
+			if i > 0 && !n.IsTight {
+				lines = append(lines, "")
+			}
+			for j, line := range block {
+				if i == 0 && j == 0 {
+					line = marker + line
+				} else if line != "" {
+					line = indent + line
+				}
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// table returns the table with its columns aligned.
+func (c *converter) table(n *extast.Table) []string {
+	var rows [][]string
+	var widths []int
+	for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for i, cell := 0, row.FirstChild(); cell != nil; i, cell = i+1, cell.NextSibling() {
+			s := c.inline(cell)
+			cells = append(cells, s)
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := utf8.RuneCountInString(s); w > widths[i] {
+				widths[i] = w
+			}
+		}
+		rows = append(rows, cells)
+	}
+
+	var lines []string
+	for _, cells := range rows {
+		var b strings.Builder
+		for i, s := range cells {
+			if i > 0 {
+				b.WriteString(" | ")
+			}
+			b.WriteString(s)
+			b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(s)))
+		}
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+	return lines
+}
+
+// inline returns the text of the node's inline children, numbering their
+// links.
+func (c *converter) inline(n ast.Node) string {
+	var buf bytes.Buffer
+	c.writeInline(&buf, n)
+	return strings.TrimSpace(buf.String())
+}
+
+func (c *converter) writeInline(buf *bytes.Buffer, n ast.Node) {
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		switch child := child.(type) {
+		case *ast.Text:
+			buf.Write(child.Segment.Value(c.source))
+			if child.HardLineBreak() {
+				buf.WriteByte('\n')
+			} else if child.SoftLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			buf.Write(child.Value)
+		case *ast.CodeSpan:
+			buf.WriteByte('`')
+			c.writeInline(buf, child)
+			buf.WriteByte('`')
+		case *ast.Emphasis:
+			marker := "_"
+			if child.Level > 1 {
+				marker = "*"
+			}
+			buf.WriteString(marker)
+			c.writeInline(buf, child)
+			buf.WriteString(marker)
+		case *ast.Link:
+			c.writeInline(buf, child)
+			fmt.Fprintf(buf, " [%d]", c.addLink(string(child.Destination)))
+		case *ast.AutoLink:
+			// The URL is already in the text.
+			buf.Write(child.Label(c.source))
+		case *ast.Image:
+			buf.WriteString("[image: ")
+			c.writeInline(buf, child)
+			fmt.Fprintf(buf, "] [%d]", c.addLink(string(child.Destination)))
+		case *ast.RawHTML:
+			// Dropped, like HTML blocks.
+		default:
+			c.writeInline(buf, child)
+		}
+	}
+}
+
+// addLink returns the number of the link.
+func (c *converter) addLink(dest string) int {
+	if c.rewrite != nil {
+		dest = c.rewrite(dest)
+	}
+	c.links = append(c.links, dest)
+	return len(c.links)
+}
+
+// wrap splits the text into lines of at most width characters, breaking at
+// spaces. Words longer than width are left whole.
+func wrap(s string, width int) []string {
+	var lines []string
+	var line strings.Builder
+	n := 0
+	for _, word := range strings.Fields(s) {
+		w := utf8.RuneCountInString(word)
+		if n > 0 && n+1+w > width {
+			lines = append(lines, line.String())
+			line.Reset()
+			n = 0
+		}
+		if n > 0 {
+			line.WriteByte(' ')
+			n++
+		}
+		line.WriteString(word)
+		n += w
+	}
+	if n > 0 || len(lines) == 0 {
+		lines = append(lines, line.String())
+	}
+	return lines
+}
@@ -0,0 +1,42 @@
+package plaintext
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	source := "A paragraph with [a link](/docs) and *emphasis*, long enough to be wrapped.\n" +
+		"\n" +
+		"## Heading\n" +
+		"\n" +
+		"- one\n" +
+		"- two items, the second of which wraps\n" +
+		"  1. nested\n" +
+		"\n" +
+		"> quoted\n" +
+		"\n" +
+		"    code  block\n" +
+		"\n" +
+		"<div>html</div>\n"
+
+	want := "A paragraph with a link [1] and\n" +
+		"_emphasis_, long enough to be\n" +
+		"wrapped.\n" +
+		"\n" +
+		"Heading\n" +
+		"=======\n" +
+		"\n" +
+		"* one\n" +
+		"* two items, the second of which\n" +
+		"  wraps\n" +
+		"  1. nested\n" +
+		"\n" +
+		"> quoted\n" +
+		"\n" +
+		"    code  block\n" +
+		"\n" +
+		"[1] https://example.org/docs\n"
+
+	rewrite := func(dest string) string { return "https://example.org" + dest }
+	if got := Render([]byte(source), 32, rewrite); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,52 @@
+// Package preview implements signed, expiring tokens granting access to
+// unpublished articles.
+package preview
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid preview token")
+	ErrExpiredToken = errors.New("preview token has expired")
+)
+
+// NewToken returns a token for the article identified by slug, valid until
+// the expiry time.
+func NewToken(secret, slug string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + sign(secret, slug, exp)
+}
+
+// Verify checks if the token is valid for the slug at the given time.
+func Verify(secret, slug, token string, now time.Time) error {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return ErrInvalidToken
+	}
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	want := sign(secret, slug, parts[0])
+	if !hmac.Equal([]byte(parts[1]), []byte(want)) {
+		return ErrInvalidToken
+	}
+	if now.Unix() > exp {
+		return ErrExpiredToken
+	}
+	return nil
+}
+
+func sign(secret, slug, exp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s", slug, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
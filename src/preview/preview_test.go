@@ -0,0 +1,33 @@
+package preview
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	token := NewToken("secret", "hello-world", now.Add(time.Hour))
+
+	tests := []struct {
+		name   string
+		secret string
+		slug   string
+		token  string
+		now    time.Time
+		want   error
+	}{
+		{"valid", "secret", "hello-world", token, now, nil},
+		{"expired", "secret", "hello-world", token, now.Add(2 * time.Hour), ErrExpiredToken},
+		{"wrong slug", "secret", "other", token, now, ErrInvalidToken},
+		{"wrong secret", "other", "hello-world", token, now, ErrInvalidToken},
+		{"tampered expiry", "secret", "hello-world", "9999999999" + token[len(token)-44:], now, ErrInvalidToken},
+		{"malformed", "secret", "hello-world", "garbage", now, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		if got := Verify(tt.secret, tt.slug, tt.token, tt.now); got != tt.want {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package safehttp provides an HTTP client for fetching URLs supplied by
+// third parties, e.g. webmention sources or ActivityPub actors, which must not
+// be used to reach the server's own network.
+package safehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned when connecting to an address that isn't
+// publicly routable.
+var ErrForbiddenAddress = errors.New("forbidden address")
+
+// forbiddenNets are the networks that can't be connected to, besides the
+// loopback, link-local, multicast and unspecified addresses.
+var forbiddenNets = parseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"10.0.0.0/8",    // private (RFC 1918)
+	"100.64.0.0/10", // shared address space (RFC 6598)
+	"172.16.0.0/12", // private (RFC 1918)
+	"192.168.0.0/16",
+	"fc00::/7", // unique local (RFC 4193)
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// IsPublic reports whether the IP address is publicly routable.
+func IsPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range forbiddenNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsPublicHost reports whether the host name or IP address can be reached
+// from other hosts on the internet, as far as can be told without resolving
+// it: localhost and addresses that aren't public can't.
+func IsPublicHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return IsPublic(ip)
+	}
+	return true
+}
+
+// control refuses the connections to addresses that aren't public. It's
+// called after the host name has been resolved, so it also applies to names
+// resolving to private addresses, and to redirects.
+func control(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !IsPublic(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// NewClient returns a client with the timeout that only connects to public
+// addresses.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager returns the manager obtaining the certificate for the host
+// from the ACME server, and renewing it before it expires. The account key
+// and the certificates are cached on disk.
+func (s *Server) newACMEManager() (*autocert.Manager, error) {
+	conf := s.app.Config()
+	dir := conf.ACMECacheDir
+	if dir == "" {
+		if conf.DataDir == "" {
+			return nil, errors.New("acme_cache_dir or data_dir must be set to use ACME")
+		}
+		dir = filepath.Join(conf.DataDir, "acme")
+	}
+
+	client := &acme.Client{DirectoryURL: conf.ACMEDirectory}
+	if conf.ACMERootCA != "" {
+		data, err := ioutil.ReadFile(conf.ACMERootCA)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in '%s'", conf.ACMERootCA)
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(dir),
+		HostPolicy: autocert.HostWhitelist(conf.Host),
+		Email:      conf.ACMEEmail,
+		Client:     client,
+	}, nil
+}
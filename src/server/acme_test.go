@@ -0,0 +1,121 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestACMEServers(t *testing.T) {
+	s := newTestServer(t, nil)
+	conf := s.app.Config()
+	conf.PortTLS = 443
+	conf.ForceTLS = true
+	conf.ACME = true
+
+	if err := s.initServers(); err == nil {
+		t.Error("want error without a cache directory")
+	}
+
+	conf.DataDir = t.TempDir()
+	s.srv, s.srvtls = nil, nil
+	if err := s.initServers(); err != nil {
+		t.Fatal(err)
+	}
+	if s.certs != nil || s.srvtls.TLSConfig.GetCertificate == nil {
+		t.Error("want certificates from the ACME manager")
+	}
+
+	// Challenges are answered on the plain HTTP server, everything else
+	// is redirected.
+	for target, redirect := range map[string]bool{
+		"/.well-known/acme-challenge/token": false,
+		"/hello":                            true,
+	} {
+		w := httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org"+target, nil))
+		if got := w.Code == http.StatusMovedPermanently; got != redirect {
+			t.Errorf("%s: want redirect %v, got status %d", target, redirect, w.Code)
+		}
+	}
+}
+
+// TestACMEPebble gets a certificate from a local Pebble server
+// (https://github.com/letsencrypt/pebble). It's skipped unless
+// PEBBLE_DIRECTORY is set to Pebble's directory URL, e.g.
+// https://localhost:14000/dir, and PEBBLE_ROOT_CA to the certificate of its
+// CA, test/certs/pebble.minica.pem. The challenges are answered on the ports
+// of Pebble's default config, 5002 for HTTP-01 and 5001 for TLS-ALPN-01;
+// PEBBLE_HOST, "localhost" by default, must resolve to this machine for
+// Pebble.
+func TestACMEPebble(t *testing.T) {
+	directory, rootCA := os.Getenv("PEBBLE_DIRECTORY"), os.Getenv("PEBBLE_ROOT_CA")
+	if directory == "" || rootCA == "" {
+		t.Skip("PEBBLE_DIRECTORY and PEBBLE_ROOT_CA not set")
+	}
+	host := os.Getenv("PEBBLE_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+
+	s := newTestServer(t, nil)
+	conf := s.app.Config()
+	conf.Host = host
+	conf.PortTLS = 443
+	conf.ACME = true
+	conf.ACMEDirectory = directory
+	conf.ACMERootCA = rootCA
+	conf.ACMEEmail = "admin@example.org"
+	conf.DataDir = t.TempDir()
+	if err := s.initServers(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve the challenges on the ports Pebble validates them on.
+	httpListener, err := net.Listen("tcp", ":5002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.srv.Serve(httpListener)
+	defer s.srv.Close()
+	tlsListener, err := net.Listen("tcp", ":5001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.srvtls.ServeTLS(tlsListener, "", "")
+	defer s.srvtls.Close()
+
+	done := make(chan struct{})
+	var cert *tls.Certificate
+	go func() {
+		defer close(done)
+		cert, err = s.srvtls.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("timed out waiting for the certificate")
+	}
+	if err != nil {
+		t.Fatalf("couldn't get certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname(host); err != nil {
+		t.Error(err)
+	}
+	// The certificate is cached for the next start, named after the host and
+	// the key type.
+	if cached, _ := filepath.Glob(filepath.Join(conf.DataDir, "acme", host+"*")); len(cached) == 0 {
+		t.Error("certificate not cached")
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"presence/activitypub"
+	"strings"
+)
+
+// outboxItems is the number of the most recent posts in the outbox.
+const outboxItems = 20
+
+// writeActivityJSON writes v as the JSON response with the given content type.
+func writeActivityJSON(w http.ResponseWriter, contentType string, v interface{}) {
+	body, err := marshalJSON(v)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(body)
+}
+
+// withFederation responds with 404 if federation isn't started.
+func (s *Server) withFederation(next func(http.ResponseWriter, *http.Request, *activitypub.Federation)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f := s.app.Federation()
+		if f == nil {
+			http.Error(w, "not found", 404)
+			return
+		}
+		next(w, r, f)
+	}
+}
+
+// handleWebFinger resolves the fediverse account of the site, or the URL of
+// its actor, to the actor.
+func (s *Server) handleWebFinger(w http.ResponseWriter, r *http.Request, f *activitypub.Federation) {
+	resource := r.URL.Query().Get("resource")
+	if resource == "" {
+		http.Error(w, "missing resource", http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(resource, "acct:"+f.Site.Handle()) && resource != f.Site.ActorID() {
+		http.Error(w, "not found", 404)
+		return
+	}
+
+	writeActivityJSON(w, "application/jrd+json", map[string]interface{}{
+		"subject": "acct:" + f.Site.Handle(),
+		"aliases": []string{f.Site.ActorID(), f.Site.BaseURL + "/"},
+		"links": []map[string]string{
+			{
+				"rel":  "self",
+				"type": activitypub.ContentType,
+				"href": f.Site.ActorID(),
+			},
+			{
+				"rel":  "http://webfinger.net/rel/profile-page",
+				"type": "text/html",
+				"href": f.Site.BaseURL + "/",
+			},
+		},
+	})
+}
+
+func (s *Server) handleActor(w http.ResponseWriter, r *http.Request, f *activitypub.Federation) {
+	conf := s.app.Config()
+	writeActivityJSON(w, activitypub.ContentType, f.Actor(conf.Title, conf.Description))
+}
+
+func (s *Server) handleOutbox(w http.ResponseWriter, r *http.Request, f *activitypub.Federation) {
+	posts := s.app.GetRecentPosts(0, outboxItems)
+	writeActivityJSON(w, activitypub.ContentType, f.Outbox(posts, s.app.PostCount()))
+}
+
+func (s *Server) handleFollowers(w http.ResponseWriter, r *http.Request, f *activitypub.Federation) {
+	writeActivityJSON(w, activitypub.ContentType, f.FollowersCollection())
+}
+
+// handleInbox receives activities from other servers.
+func (s *Server) handleInbox(w http.ResponseWriter, r *http.Request, f *activitypub.Federation) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err = f.Receive(r, body)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusAccepted)
+	case errors.Is(err, activitypub.ErrInvalidActivity):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, activitypub.ErrUnauthorized):
+		log.Printf("rejected activity: %v\n", err)
+		http.Error(w, activitypub.ErrUnauthorized.Error(), http.StatusUnauthorized)
+	default:
+		log.Println(err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
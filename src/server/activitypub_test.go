@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestActivityPub(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"hello-world.1.md": "# Hello, world!\n\nHi.",
+	})
+	h := s.newRouter()
+
+	do := func(method, target, accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/actor", "", ""); w.Code != 404 {
+		t.Errorf("federation not started: want status 404, got %d", w.Code)
+	}
+
+	dir, err := ioutil.TempDir("", "presence_test_server_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s.app.Config().DataDir = filepath.Join(dir, "data")
+	s.app.Config().FediverseUsername = "blog"
+	if err := s.app.StartFederation(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := do("GET", "/.well-known/webfinger?resource=acct:blog@example.org", "", "")
+	var jrd struct {
+		Subject string
+		Links   []struct{ Rel, Type, Href string }
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jrd); err != nil || w.Code != 200 {
+		t.Fatalf("webfinger: status %d, %v", w.Code, err)
+	}
+	if jrd.Subject != "acct:blog@example.org" || jrd.Links[0].Href != "http://example.org/actor" {
+		t.Errorf("unexpected webfinger response: %+v", jrd)
+	}
+	if w := do("GET", "/.well-known/webfinger?resource=acct:other@example.org", "", ""); w.Code != 404 {
+		t.Errorf("unknown account: want status 404, got %d", w.Code)
+	}
+
+	w = do("GET", "/actor", "", "")
+	var actor struct {
+		Type      string
+		Inbox     string
+		PublicKey struct{ PublicKeyPem string }
+	}
+	json.Unmarshal(w.Body.Bytes(), &actor)
+	if actor.Type != "Person" || actor.Inbox != "http://example.org/inbox" ||
+		!strings.Contains(actor.PublicKey.PublicKeyPem, "PUBLIC KEY") {
+		t.Errorf("unexpected actor: %s", w.Body)
+	}
+
+	w = do("GET", "/outbox", "", "")
+	var outbox struct {
+		TotalItems   int
+		OrderedItems []struct {
+			Type   string
+			Object struct{ ID, Name string }
+		}
+	}
+	json.Unmarshal(w.Body.Bytes(), &outbox)
+	if outbox.TotalItems != 1 || len(outbox.OrderedItems) != 1 ||
+		outbox.OrderedItems[0].Type != "Create" ||
+		outbox.OrderedItems[0].Object.ID != "http://example.org/hello-world" {
+		t.Errorf("unexpected outbox: %s", w.Body)
+	}
+
+	// Posts are served as ActivityPub objects on request.
+	w = do("GET", "/hello-world", "application/activity+json", "")
+	if ct := w.Header().Get("Content-Type"); ct != "application/activity+json" {
+		t.Errorf("want ActivityPub object, got %s", ct)
+	}
+	if w := do("GET", "/hello-world", "text/html", ""); w.Header().Get("Content-Type") == "application/activity+json" {
+		t.Error("want HTML page, got ActivityPub object")
+	}
+
+	body := `{"type":"Follow","actor":"http://127.0.0.1:1/alice","object":"http://example.org/actor"}`
+	if w := do("POST", "/inbox", "", body); w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned activity: want status 401, got %d", w.Code)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"presence/model"
+	"presence/plaintext"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// textWidth is the width at which plain-text article bodies are wrapped.
+	textWidth = 70
+
+	// textDateFormat is the format of the dates in plain text, gemtext and
+	// gopher menus.
+	textDateFormat = "2006-01-02"
+)
+
+// acceptsMarkdown reports whether the client prefers Markdown to HTML,
+// asking for it explicitly.
+func acceptsMarkdown(r *http.Request) bool {
+	var markdown, html float64
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mt {
+		case "text/markdown":
+			markdown = q
+		case "text/html", "text/*", "*/*":
+			if q > html {
+				html = q
+			}
+		}
+	}
+	return markdown > 0 && markdown >= html
+}
+
+// handleArticleMarkdown serves the source of the article, front matter
+// included.
+func (s *Server) handleArticleMarkdown(w http.ResponseWriter, r *http.Request) {
+	a := s.articleAt(mux.Vars(r)["slug"])
+	if a == nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Write(a.BodyRaw)
+}
+
+// handleArticleText serves the article as plain text.
+func (s *Server) handleArticleText(w http.ResponseWriter, r *http.Request) {
+	a := s.articleAt(mux.Vars(r)["slug"])
+	if a == nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(strings.TrimRight(s.articleText(a, s.textLink), "\n") + "\n"))
+}
+
+// textLink returns the absolute URL of root-relative links.
+func (s *Server) textLink(dest string) string {
+	if strings.HasPrefix(dest, "/") && !strings.HasPrefix(dest, "//") {
+		return s.absURL(dest)
+	}
+	return dest
+}
+
+// articleText renders the article as plain text, with its title, its date
+// for posts, and its tags. Links are passed through link.
+func (s *Server) articleText(a *model.Article, link func(string) string) string {
+	var b strings.Builder
+	title := articleTitle(a)
+	fmt.Fprintf(&b, "%s\n%s\n\n", title, strings.Repeat("=", len([]rune(title))))
+	// Pages have no publication date to show.
+	if s.app.GetPage(a.Slug) == nil && a.PubTime != nil {
+		fmt.Fprintf(&b, "%s\n\n", a.PubTime.Format(textDateFormat))
+	}
+	if body := plaintext.Render(a.BodyMarkdown, textWidth, link); body != "" {
+		b.WriteString(body)
+		b.WriteString("\n")
+	}
+	if len(a.Tags) > 0 {
+		fmt.Fprintf(&b, "Tags: %s\n\n", strings.Join(a.Tags, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsMarkdown(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", false},
+		{"text/markdown", true},
+		{"text/markdown, text/html;q=0.9", true},
+		{"text/html, text/markdown;q=0.5", false},
+		{"text/markdown;q=0, */*", false},
+		{"text/markdown; charset=utf-8, */*;q=0.1", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/post", nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := acceptsMarkdown(r); got != tt.want {
+			t.Errorf("%q: want %v, got %v", tt.accept, tt.want, got)
+		}
+	}
+}
+
+func TestArticleAlternates(t *testing.T) {
+	source := "---\ntags: [misc]\n---\n# Post\n\nSee [the archive](/archive)."
+	s := newTestServer(t, map[string]string{"post.946728000.md": source})
+	if err := s.initTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	h := s.newRouter()
+
+	do := func(target, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("Accept", accept)
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("/post.md", "")
+	if w.Code != 200 || w.Body.String() != source {
+		t.Errorf("/post.md: unexpected response %d: %q", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/markdown; charset=utf-8" {
+		t.Errorf("/post.md: unexpected content type %q", ct)
+	}
+
+	w = do("/post.txt", "")
+	want := "Post\n====\n\n2000-01-01\n\nSee the archive [1].\n\n[1] http://example.org/archive\n\nTags: misc\n"
+	if w.Code != 200 || w.Body.String() != want {
+		t.Errorf("/post.txt: want %q, got %d: %q", want, w.Code, w.Body)
+	}
+
+	w = do("/post", "text/markdown")
+	if w.Body.String() != source || w.Header().Get("Vary") != "Accept" {
+		t.Errorf("/post: want Markdown varying on Accept, got %q (Vary: %q)", w.Body, w.Header().Get("Vary"))
+	}
+
+	w = do("/post", "text/html")
+	if !strings.Contains(w.Body.String(), `<link rel="alternate" title="Post" type="text/markdown" href="/post.md" />`) {
+		t.Errorf("/post: missing alternate link in:\n%s", w.Body)
+	}
+
+	for _, target := range []string{"/missing.md", "/missing.txt"} {
+		if w := do(target, ""); w.Code != 404 {
+			t.Errorf("%s: want 404, got %d", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"presence/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxAPIPerPage = 100
+
+type apiArticle struct {
+	Slug        string                 `json:"slug"`
+	URL         string                 `json:"url"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	Author      string                 `json:"author,omitempty"`
+	Published   *time.Time             `json:"published,omitempty"`
+	Updated     *time.Time             `json:"updated,omitempty"`
+	Modified    time.Time              `json:"modified"`
+	Tags        []string               `json:"tags"`
+	Aliases     []string               `json:"aliases,omitempty"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+	Draft       bool                   `json:"draft,omitempty"`
+	Source      string                 `json:"source"`
+	Markdown    string                 `json:"markdown"`
+	HTML        string                 `json:"html"`
+}
+
+func (s *Server) newAPIArticle(a *model.Article) *apiArticle {
+	tags := a.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return &apiArticle{
+		Slug:        a.Slug,
+		URL:         s.absURL("/" + a.Slug),
+		Title:       a.Title,
+		Description: a.Description,
+		Author:      a.Author,
+		Published:   a.PubTime,
+		Updated:     a.Updated,
+		Modified:    a.ModTime,
+		Tags:        tags,
+		Aliases:     a.Aliases,
+		Params:      a.Params,
+		Draft:       a.Draft,
+		Source:      string(a.BodyRaw),
+		Markdown:    string(a.BodyMarkdown),
+		HTML:        a.BodyHTML,
+	}
+}
+
+func (s *Server) newAPIArticleSlice(articles []*model.Article) []*apiArticle {
+	result := make([]*apiArticle, 0, len(articles))
+	for _, a := range articles {
+		result = append(result, s.newAPIArticle(a))
+	}
+	return result
+}
+
+// etag returns a strong entity tag for the response body.
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func marshalJSON(v interface{}) ([]byte, error) {
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// writeJSON writes v as the JSON response, answering with 304 Not Modified if
+// the client already has the current representation.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	writeJSONStatus(w, r, http.StatusOK, v)
+}
+
+// writeJSONStatus writes v as the JSON response with the given status code.
+func writeJSONStatus(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	body, err := marshalJSON(v)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	tag := etag(body)
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+	if code == http.StatusOK && etagMatches(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// etagMatches checks if the If-None-Match or If-Match header value matches
+// the entity tag. Weak validators are compared by their opaque tag.
+func etagMatches(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// queryInt parses the integer query parameter, returning def if it's unset.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// queryDate parses the date query parameter (YYYY-MM-DD), returning nil if
+// it's unset.
+func queryDate(r *http.Request, name string) (*time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// handleAPIPosts serves a page of posts, optionally filtered by tag and by
+// publication date. The date range includes both ends.
+func (s *Server) handleAPIPosts(w http.ResponseWriter, r *http.Request) {
+	page, err := queryInt(r, "page", 1)
+	if err != nil || page < 1 {
+		writeJSONError(w, http.StatusBadRequest, "invalid page")
+		return
+	}
+	perPage, err := queryInt(r, "per_page", int(s.app.Config().MaxEntriesPerPage))
+	if err != nil || perPage < 1 || perPage > maxAPIPerPage {
+		writeJSONError(w, http.StatusBadRequest, "invalid per_page")
+		return
+	}
+	from, err := queryDate(r, "from")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid from date")
+		return
+	}
+	to, err := queryDate(r, "to")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid to date")
+		return
+	}
+
+	var posts []*model.Article
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		posts = s.app.GetPostsByTag(tag)
+	} else {
+		posts = s.app.GetAllPosts()
+	}
+
+	filtered := make([]*model.Article, 0, len(posts))
+	for _, p := range posts {
+		if from != nil && p.PubTime.Before(*from) {
+			continue
+		}
+		if to != nil && !p.PubTime.Before(to.AddDate(0, 0, 1)) {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+
+	// Pages past the end are empty. This is checked before multiplying, which
+	// could overflow for huge pages.
+	start, end := len(filtered), len(filtered)
+	if page-1 <= len(filtered)/perPage {
+		start = (page - 1) * perPage
+		if start+perPage < end {
+			end = start + perPage
+		}
+	}
+
+	data := struct {
+		Page    int           `json:"page"`
+		PerPage int           `json:"per_page"`
+		Total   int           `json:"total"`
+		Posts   []*apiArticle `json:"posts"`
+	}{
+		page,
+		perPage,
+		len(filtered),
+		s.newAPIArticleSlice(filtered[start:end]),
+	}
+
+	writeJSON(w, r, data)
+}
+
+// handleAPIPost serves a single post. Unpublished posts are only available to
+// authorized clients.
+func (s *Server) handleAPIPost(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+	post := s.app.GetPost(slug)
+	if post == nil && s.authorized(r) {
+		post = s.app.LookupPost(slug)
+	}
+	if post == nil {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, r, s.newAPIArticle(post))
+}
+
+func (s *Server) handleAPIPages(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Pages []*apiArticle `json:"pages"`
+	}{
+		s.newAPIArticleSlice(s.app.GetAllPages()),
+	}
+	writeJSON(w, r, data)
+}
+
+func (s *Server) handleAPISite(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Title       string            `json:"title"`
+		Author      string            `json:"author"`
+		Description string            `json:"description"`
+		URL         string            `json:"url"`
+		Posts       int               `json:"posts"`
+		Feeds       map[string]string `json:"feeds"`
+	}{
+		s.app.Config().Title,
+		s.app.Config().Author,
+		s.app.Config().Description,
+		s.BaseURL(),
+		s.app.PostCount(),
+		map[string]string{
+			"rss":  s.absURL("/rss.xml"),
+			"atom": s.absURL("/atom.xml"),
+			"json": s.absURL("/feed.json"),
+		},
+	}
+	writeJSON(w, r, data)
+}
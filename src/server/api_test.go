@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIPosts(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"old.946684800.md":    "---\ntags: [misc]\n---\n# Old",     // 2000-01-01
+		"new.1609459200.md":   "---\ntags: [misc, go]\n---\n# New", // 2021-01-01
+		"other.1577836800.md": "# Other",                           // 2020-01-01
+	})
+	r := s.newRouter()
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/api/v1/posts", []string{"new", "other", "old"}},
+		{"/api/v1/posts?tag=misc", []string{"new", "old"}},
+		{"/api/v1/posts?tag=go", []string{"new"}},
+		{"/api/v1/posts?from=2019-06-01", []string{"new", "other"}},
+		{"/api/v1/posts?to=2020-01-01", []string{"other", "old"}},
+		{"/api/v1/posts?per_page=2&page=2", []string{"old"}},
+		{"/api/v1/posts?page=3&per_page=2", []string{}},
+		{"/api/v1/posts?page=9223372036854775807&per_page=2", []string{}},
+	}
+
+	for _, tt := range tests {
+		w := get(r.ServeHTTP, tt.target)
+		if w.Code != 200 {
+			t.Errorf("%s: want status 200, got %d", tt.target, w.Code)
+			continue
+		}
+		var data struct {
+			Posts []struct{ Slug string }
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(data.Posts))
+		for _, p := range data.Posts {
+			got = append(got, p.Slug)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.target, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: want %v, got %v", tt.target, tt.want, got)
+				break
+			}
+		}
+	}
+
+	if w := get(r.ServeHTTP, "/api/v1/posts?page=0"); w.Code != http.StatusBadRequest {
+		t.Errorf("want status 400 for invalid page, got %d", w.Code)
+	}
+}
+
+func TestAPIConditionalGet(t *testing.T) {
+	s := newTestServer(t, map[string]string{"post.1.md": "# Post"})
+	r := s.newRouter()
+
+	w := get(r.ServeHTTP, "/api/v1/posts/post")
+	tag := w.Header().Get("ETag")
+	if w.Code != 200 || tag == "" {
+		t.Fatalf("want status 200 with ETag, got %d (ETag: %q)", w.Code, tag)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/posts/post", nil)
+	req.Header.Set("If-None-Match", tag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("want status 304, got %d", w.Code)
+	}
+}
+
+func TestAPIWrite(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.app.Config().APITokens = []string{"secret"}
+	r := s.newRouter()
+
+	do := func(method, target, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Unauthorized.
+	req := httptest.NewRequest("POST", "/api/v1/posts", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("want status 401 without token, got %d", w.Code)
+	}
+
+	w = do("POST", "/api/v1/posts", `{"slug": "hello", "source": "# Hello"}`, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status 201, got %d: %s", w.Code, w.Body)
+	}
+	if loc := w.Header().Get("Location"); loc != "/api/v1/posts/hello" {
+		t.Errorf("unexpected Location: %s", loc)
+	}
+	tag := w.Header().Get("ETag")
+	if got := get(r.ServeHTTP, "/api/v1/posts/hello").Header().Get("ETag"); got != tag {
+		t.Errorf("ETag mismatch between create and get: %s != %s", tag, got)
+	}
+
+	if w := do("POST", "/api/v1/posts", `{"slug": "hello", "source": "# Hello"}`, ""); w.Code != http.StatusConflict {
+		t.Errorf("want status 409 for existing slug, got %d", w.Code)
+	}
+	if w := do("POST", "/api/v1/posts", `{"slug": "a.b", "source": "# Hello"}`, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("want status 400 for invalid slug, got %d", w.Code)
+	}
+
+	body := `{"source": "# Hello again"}`
+	if w := do("PUT", "/api/v1/posts/hello", body, ""); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("want status 428 without If-Match, got %d", w.Code)
+	}
+	if w := do("PUT", "/api/v1/posts/hello", body, `"stale"`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("want status 412 for stale If-Match, got %d", w.Code)
+	}
+	w = do("PUT", "/api/v1/posts/hello", body, tag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d: %s", w.Code, w.Body)
+	}
+	if s.app.GetPost("hello").Title != "Hello again" {
+		t.Error("post not updated")
+	}
+
+	// The old ETag is no longer valid.
+	if w := do("DELETE", "/api/v1/posts/hello", "", tag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("want status 412 for stale If-Match, got %d", w.Code)
+	}
+	if w := do("DELETE", "/api/v1/posts/hello", "", w.Header().Get("ETag")); w.Code != http.StatusNoContent {
+		t.Errorf("want status 204, got %d", w.Code)
+	}
+	if s.app.GetPost("hello") != nil {
+		t.Error("post not deleted")
+	}
+}
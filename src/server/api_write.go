@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"presence/store"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxAPIBodySize is the request body size limit for the API endpoints.
+const maxAPIBodySize = 1 << 20
+
+type apiPostRequest struct {
+	Slug   string `json:"slug"`
+	Source string `json:"source"`
+}
+
+// authorized checks the request's bearer token against the configured API
+// tokens.
+func (s *Server) authorized(r *http.Request) bool {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return false
+	}
+	return s.validToken(strings.TrimPrefix(h, "Bearer "))
+}
+
+// validToken checks the token against the configured API tokens.
+func (s *Server) validToken(token string) bool {
+	for _, t := range s.app.Config().APITokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// withAuth only lets through requests with a valid API token. Write
+// endpoints are disabled if no tokens are configured.
+func (s *Server) withAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.app.Config().APITokens) == 0 {
+			writeJSONError(w, http.StatusNotFound, "write API is disabled")
+			return
+		}
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="presence"`)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func decodePostRequest(w http.ResponseWriter, r *http.Request) (*apiPostRequest, bool) {
+	var req apiPostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return nil, false
+	}
+	return &req, true
+}
+
+// writeStoreError responds with the status code corresponding to the error
+// returned by a store write operation.
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch err {
+	case store.ErrInvalidSlug:
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	case store.ErrExists:
+		writeJSONError(w, http.StatusConflict, err.Error())
+	case store.ErrNotFound:
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	default:
+		// Content errors, e.g. invalid front matter.
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+	}
+}
+
+// checkPrecondition verifies the If-Match header against the current
+// representation of the post, for optimistic concurrency control. It writes
+// the error response and returns false if the request can't proceed.
+func (s *Server) checkPrecondition(w http.ResponseWriter, r *http.Request, slug string) bool {
+	post := s.app.LookupPost(slug)
+	if post == nil {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return false
+	}
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		writeJSONError(w, http.StatusPreconditionRequired, "If-Match header is required")
+		return false
+	}
+	body, err := marshalJSON(s.newAPIArticle(post))
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		return false
+	}
+	if !etagMatches(header, etag(body)) {
+		writeJSONError(w, http.StatusPreconditionFailed, "post has been modified")
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleAPICreatePost(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePostRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	post, err := s.app.CreatePost(req.Slug, []byte(req.Source))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("created post via API: '%s'\n", post.Slug)
+
+	w.Header().Set("Location", "/api/v1/posts/"+post.Slug)
+	writeJSONStatus(w, r, http.StatusCreated, s.newAPIArticle(post))
+}
+
+func (s *Server) handleAPIUpdatePost(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+	req, ok := decodePostRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	if !s.checkPrecondition(w, r, slug) {
+		return
+	}
+	post, err := s.app.UpdatePost(slug, []byte(req.Source))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("updated post via API: '%s'\n", post.Slug)
+
+	writeJSON(w, r, s.newAPIArticle(post))
+}
+
+func (s *Server) handleAPIDeletePost(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	if !s.checkPrecondition(w, r, slug) {
+		return
+	}
+	if err := s.app.DeletePost(slug); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("deleted post via API: '%s'\n", slug)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"presence/activitypub"
+	"presence/store"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	// cachedRoutePrefix starts the names of the routes behind the page cache.
+	cachedRoutePrefix = "cached "
+
+	// Responses that aren't requested for a while are dropped to free the
+	// memory.
+	cacheExpiration = time.Hour
+	cacheCleanup    = 10 * time.Minute
+
+	// Responses are compressed once, so the compression levels are high. The
+	// best level of brotli is much slower for little gain.
+	gzipLevel   = gzip.BestCompression
+	brotliLevel = 9
+)
+
+var homePath = regexp.MustCompile(`^/([0-9]+/)?$`)
+
+// feedPaths are the paths of the feeds of the recent posts.
+var feedPaths = []string{"/rss.xml", "/atom.xml", "/feed.json"}
+
+// pageCache holds the rendered responses of the home pages, the archive, the
+// feeds and the articles, compressed ahead of time. Responses are keyed by
+// path and negotiated representation, and purged when the content they show
+// changes.
+type pageCache struct {
+	items      *cache.Cache // *cachedResponse by cacheKey
+	mux        sync.Mutex
+	generation uint64 // increased by every purge
+}
+
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+	gzip   []byte
+	brotli []byte
+}
+
+// initCache creates the page cache and subscribes it to the changes to the
+// content. Nothing is cached in dev mode.
+func (s *Server) initCache() {
+	c := &pageCache{items: cache.New(cacheExpiration, cacheCleanup)}
+	s.app.SubscribePosts(func(e store.Event) {
+		if e.Type != store.EventDraft {
+			c.purgePost(e.Article.Slug)
+		}
+	})
+	// Pages are listed in the navigation of every page.
+	s.app.SubscribePages(func(e store.Event) {
+		if e.Type != store.EventDraft {
+			c.flush()
+		}
+	})
+	s.app.SubscribeWebmentions(func(slug string) {
+		c.purge(func(path string) bool { return path == "/"+slug })
+	})
+	s.cache = c
+}
+
+// withCache serves the response from the page cache, rendering and caching it
+// first if needed. Only successful responses to GET and HEAD requests without
+// a query are cached.
+func (s *Server) withCache(next http.HandlerFunc) http.HandlerFunc {
+	compressed := handlers.CompressHandler(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Cached routes aren't compressed by the server, see withCompression.
+		if s.cache == nil || s.dev != nil || (r.Method != "GET" && r.Method != "HEAD") || r.URL.RawQuery != "" {
+			compressed.ServeHTTP(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+		if resp, ok := s.cache.get(key); ok {
+			resp.serve(w, r)
+			return
+		}
+
+		generation := s.cache.current()
+		rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+		next(rec, r)
+		if rec.status != http.StatusOK {
+			rec.copyTo(w)
+			return
+		}
+		resp := newCachedResponse(rec.header, rec.body.Bytes())
+		s.cache.put(key, generation, resp)
+		resp.serve(w, r)
+	}
+}
+
+// cacheKey returns the key of the response to the request: its path, and the
+// representation negotiated by handleArticle.
+func cacheKey(r *http.Request) string {
+	variant := "html"
+	if acceptsMarkdown(r) {
+		variant = "markdown"
+	} else if activitypub.Accepts(r) {
+		variant = "activity"
+	}
+	return r.URL.Path + " " + variant
+}
+
+func (c *pageCache) get(key string) (*cachedResponse, bool) {
+	v, ok := c.items.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*cachedResponse), true
+}
+
+// current returns the number of purges. It may be called on a nil cache.
+func (c *pageCache) current() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.generation
+}
+
+// put caches the response rendered at the generation, unless it has been
+// purged since.
+func (c *pageCache) put(key string, generation uint64, resp *cachedResponse) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if generation == c.generation {
+		c.items.Set(key, resp, cache.DefaultExpiration)
+	}
+}
+
+// purge removes the responses for the paths matching the function. It may be
+// called on a nil cache.
+func (c *pageCache) purge(match func(path string) bool) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.generation++
+	for key := range c.items.Items() {
+		if match(key[:strings.LastIndexByte(key, ' ')]) {
+			c.items.Delete(key)
+		}
+	}
+}
+
+// purgePost removes the responses showing the post: its own, the home pages,
+// the archive and the feeds.
+func (c *pageCache) purgePost(slug string) {
+	c.purge(func(path string) bool {
+		if path == "/"+slug || path == "/archive" || homePath.MatchString(path) {
+			return true
+		}
+		for _, p := range feedPaths {
+			if path == p {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// flush removes all the responses. It may be called on a nil cache.
+func (c *pageCache) flush() {
+	c.purge(func(string) bool { return true })
+}
+
+func newCachedResponse(header http.Header, body []byte) *cachedResponse {
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(body))
+	}
+	resp := &cachedResponse{header: header, body: body}
+
+	var buf bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&buf, gzipLevel)
+	gw.Write(body)
+	gw.Close()
+	resp.gzip = append([]byte(nil), buf.Bytes()...)
+
+	buf.Reset()
+	bw := brotli.NewWriterLevel(&buf, brotliLevel)
+	bw.Write(body)
+	bw.Close()
+	resp.brotli = append([]byte(nil), buf.Bytes()...)
+
+	return resp
+}
+
+// serve writes the response, in the best encoding accepted by the client.
+func (c *cachedResponse) serve(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for k, v := range c.header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Add("Vary", "Accept-Encoding")
+
+	body := c.body
+	switch {
+	case acceptsEncoding(r, "br"):
+		h.Set("Content-Encoding", "br")
+		body = c.brotli
+	case acceptsEncoding(r, "gzip"):
+		h.Set("Content-Encoding", "gzip")
+		body = c.gzip
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of the request
+// lists the content coding with a non-zero quality.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), coding) {
+			continue
+		}
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// responseRecorder buffers the response of a handler.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
+
+// copyTo writes the buffered response, uncompressed.
+func (rec *responseRecorder) copyTo(w http.ResponseWriter) {
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
+
+// withCompression compresses the responses for the clients supporting it,
+// except those of the routes behind the page cache, which are compressed
+// ahead of time.
+func withCompression(router *mux.Router, next http.Handler) http.Handler {
+	compressed := handlers.CompressHandler(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m mux.RouteMatch
+		if router.Match(r, &m) && strings.HasPrefix(m.Route.GetName(), cachedRoutePrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		compressed.ServeHTTP(w, r)
+	})
+}
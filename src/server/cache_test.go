@@ -0,0 +1,128 @@
+package server
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=1.0, *;q=0.5", true},
+		{"GZIP", true},
+		{"gzip;q=0", false},
+		{"br, gzip; q=0.0", false},
+		{"x-gzip", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", tt.header)
+		if got := acceptsEncoding(r, "gzip"); got != tt.want {
+			t.Errorf("%q: want %v, got %v", tt.header, tt.want, got)
+		}
+	}
+}
+
+func TestPageCache(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"first.946728000.md":  "# First",
+		"second.946814400.md": "# Second",
+	})
+	if err := s.initTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	s.initCache()
+	h := s.newRouter()
+
+	do := func(target string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", target, nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+	cached := func(key string) bool {
+		_, ok := s.cache.get(key)
+		return ok
+	}
+
+	w := do("/first", nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "First") {
+		t.Fatalf("unexpected response %d: %q", w.Code, w.Body)
+	}
+	page := w.Body.String()
+	if !cached("/first html") {
+		t.Fatal("page not cached")
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	// The encodings are served from the same entry.
+	w = do("/first", map[string]string{"Accept-Encoding": "gzip, deflate, br"})
+	if enc := w.Header().Get("Content-Encoding"); enc != "br" {
+		t.Fatalf("want brotli encoding, got %q", enc)
+	}
+	if body, err := ioutil.ReadAll(brotli.NewReader(w.Body)); err != nil || string(body) != page {
+		t.Errorf("brotli: unexpected body %q (%v)", body, err)
+	}
+	w = do("/first", map[string]string{"Accept-Encoding": "gzip, br;q=0"})
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("want gzip encoding, got %q", enc)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := ioutil.ReadAll(zr); err != nil || string(body) != page {
+		t.Errorf("gzip: unexpected body %q (%v)", body, err)
+	}
+
+	// Negotiated representations are cached separately.
+	w = do("/first", map[string]string{"Accept": "text/markdown"})
+	if w.Body.String() != "# First" || !cached("/first markdown") {
+		t.Errorf("markdown: unexpected response %q", w.Body)
+	}
+
+	// Requests with a query and errors aren't cached.
+	do("/second?ref=aggregator", nil)
+	if w := do("/missing", nil); w.Code != 404 || cached("/missing html") || cached("/second html") {
+		t.Errorf("unexpected cached responses")
+	}
+
+	for _, target := range []string{"/", "/archive", "/rss.xml", "/second"} {
+		do(target, nil)
+	}
+	if _, err := s.app.UpdatePost("second", []byte("# Second, edited")); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"/ html", "/archive html", "/rss.xml html", "/second html"} {
+		if cached(key) {
+			t.Errorf("%s: not purged after the post changed", key)
+		}
+	}
+	if !cached("/first html") || !cached("/first markdown") {
+		t.Error("unaffected post purged")
+	}
+	if w := do("/second", nil); !strings.Contains(w.Body.String(), "Second, edited") {
+		t.Errorf("stale page served: %q", w.Body)
+	}
+
+	if err := s.reloadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if cached("/first html") || cached("/second html") {
+		t.Error("not purged after the templates reloaded")
+	}
+}
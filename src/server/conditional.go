@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// changes tracks the changes affecting every page besides those to the
+// content: template and configuration reloads.
+type changes struct {
+	mux      sync.Mutex
+	count    uint64
+	modified time.Time
+}
+
+func (c *changes) touch() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.count++
+	c.modified = time.Now()
+}
+
+func (c *changes) get() (uint64, time.Time) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.count, c.modified
+}
+
+// validators returns the entity tag and the modification time of the
+// response to the request, derived from the state of the content, the
+// templates, the configuration and the page cache. The tag also depends on the headers the
+// representation is negotiated with.
+func (s *Server) validators(r *http.Request) (string, time.Time) {
+	content, modified := s.app.State()
+	count, changed := s.changes.get()
+	if changed.After(modified) {
+		modified = changed
+	}
+
+	// The modification times tell the states apart across restarts, when
+	// the counters start over. The stores count their changes before the
+	// page cache is purged, so the responses still cached in between are
+	// tagged with the purges too: the tags they get stop matching once the
+	// cache is purged.
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%d\n%d\n%d\n%s\n%s\n", content, count, s.cache.current(), modified.UnixNano(),
+		r.Header.Get("Accept"), r.Header.Get("Accept-Encoding"))
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, modified
+}
+
+// withValidators answers conditional requests with 304 Not Modified when the
+// content hasn't changed, without calling next. Other responses carry the
+// validators and the configured Cache-Control header.
+func (s *Server) withValidators(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Pages are reloaded on every change in dev mode anyway.
+		if s.dev != nil || (r.Method != "GET" && r.Method != "HEAD") {
+			next(w, r)
+			return
+		}
+
+		tag, modified := s.validators(r)
+		w.Header().Set("ETag", tag)
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		if cc := s.app.Config().CacheControl; cc != "" {
+			w.Header().Set("Cache-Control", cc)
+		}
+		if notModified(r, tag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// notModified reports whether the client's copy is current. If-None-Match
+// takes precedence over If-Modified-Since.
+func notModified(r *http.Request, tag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, tag)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(ims)
+}
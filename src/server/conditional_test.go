@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConditionalGet(t *testing.T) {
+	s := newTestServer(t, map[string]string{"post.1.md": "# Post"})
+	s.app.Config().CacheControl = "no-cache"
+	h := s.newRouter()
+
+	do := func(target string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", target, nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("/post.md", nil)
+	tag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if w.Code != 200 || tag == "" || modified == "" {
+		t.Fatalf("want status 200 with validators, got %d (ETag: %q, Last-Modified: %q)", w.Code, tag, modified)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("want Cache-Control no-cache, got %q", cc)
+	}
+
+	if w := do("/post.md", map[string]string{"If-None-Match": tag}); w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("If-None-Match: want 304 with no body, got %d", w.Code)
+	}
+	if w := do("/post.md", map[string]string{"If-Modified-Since": modified}); w.Code != 304 {
+		t.Errorf("If-Modified-Since: want 304, got %d", w.Code)
+	}
+	// If-None-Match takes precedence.
+	if w := do("/post.md", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modified}); w.Code != 200 {
+		t.Errorf("If-None-Match mismatch: want 200, got %d", w.Code)
+	}
+	// Representations negotiated differently have different tags.
+	if w := do("/post.md", map[string]string{"If-None-Match": tag, "Accept-Encoding": "gzip"}); w.Code != 200 {
+		t.Errorf("other encoding: want 200, got %d", w.Code)
+	}
+
+	// Any change to the content or the templates changes the tag.
+	if _, err := s.app.CreatePost("new", []byte("# New")); err != nil {
+		t.Fatal(err)
+	}
+	w = do("/post.md", map[string]string{"If-None-Match": tag})
+	if w.Code != 200 {
+		t.Errorf("after a new post: want 200, got %d", w.Code)
+	}
+	tag = w.Header().Get("ETag")
+	s.changes.touch()
+	if w := do("/post.md", map[string]string{"If-None-Match": tag}); w.Code != 200 {
+		t.Errorf("after a template reload: want 200, got %d", w.Code)
+	}
+
+	// Purging the page cache changes the tag too, since the responses served
+	// from the cache before the purge may be stale.
+	s.initCache()
+	tag = do("/post.md", nil).Header().Get("ETag")
+	s.cache.purgePost("post")
+	if w := do("/post.md", map[string]string{"If-None-Match": tag}); w.Code != 200 {
+		t.Errorf("after a purge: want 200, got %d", w.Code)
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if w := do("/rss.xml", map[string]string{"If-Modified-Since": future}); w.Code != 304 {
+		t.Errorf("feed: want 304, got %d", w.Code)
+	}
+}
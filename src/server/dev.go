@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"presence/store"
+	"strconv"
+	"sync"
+)
+
+// devReloader tells the browsers to refresh the pages when the site changes,
+// using Server-Sent Events. Each change increments the generation. Pages are
+// rendered with the generation they show, so that changes made while a
+// browser was reconnecting aren't missed.
+type devReloader struct {
+	gen     uint64
+	clients map[chan uint64]bool
+	mux     sync.Mutex
+}
+
+func newDevReloader() *devReloader {
+	return &devReloader{clients: make(map[chan uint64]bool)}
+}
+
+func (d *devReloader) generation() uint64 {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.gen
+}
+
+// changed sends a reload event to the connected browsers.
+func (d *devReloader) changed() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.gen++
+	for c := range d.clients {
+		select {
+		case c <- d.gen:
+		default:
+		}
+	}
+}
+
+// script returns the script reloading the page rendered at the current
+// generation.
+func (d *devReloader) script() []byte {
+	return []byte(fmt.Sprintf(`<script>new EventSource("/_dev/events?since=%d").`+
+		`addEventListener("reload", function() { location.reload(); });</script>`, d.generation()))
+}
+
+// ServeHTTP streams a reload event as soon as the site has changed since the
+// generation in the since query parameter.
+func (d *devReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	since, _ := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+
+	c := make(chan uint64, 1)
+	d.mux.Lock()
+	d.clients[c] = true
+	gen := d.gen
+	d.mux.Unlock()
+	defer func() {
+		d.mux.Lock()
+		delete(d.clients, c)
+		d.mux.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, "retry: 1000\n\n")
+	flusher.Flush()
+
+	if gen <= since {
+		select {
+		case gen = <-c:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	fmt.Fprintf(w, "event: reload\ndata: %d\n\n", gen)
+	flusher.Flush()
+}
+
+// injectScript inserts the script before the closing body tag of the page, or
+// appends it if there is none.
+func injectScript(page, script []byte) []byte {
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		return append(page, script...)
+	}
+	out := make([]byte, 0, len(page)+len(script))
+	out = append(out, page[:i]...)
+	out = append(out, script...)
+	return append(out, page[i:]...)
+}
+
+// EnableDevMode makes the pages refresh themselves in the browser whenever a
+// post, page, template or static file changes. It must be called before Run.
+func (s *Server) EnableDevMode() error {
+	dev := newDevReloader()
+	// The static dir only holds the overrides of the default theme, if any.
+	if dir := s.app.Config().StaticDir; dirExists(dir) {
+		watcher, err := watchDirs([]string{dir}, dev.changed)
+		if err != nil {
+			return err
+		}
+		s.devWatcher = watcher
+	}
+	s.app.Subscribe(func(store.Event) { dev.changed() })
+
+	s.dev = dev
+	log.Println("dev mode: pages reload on changes")
+	return nil
+}
+
+func (s *Server) handleDevEvents(w http.ResponseWriter, r *http.Request) {
+	if s.dev == nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	s.dev.ServeHTTP(w, r)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInjectScript(t *testing.T) {
+	script := []byte("<script></script>")
+	tests := []struct{ page, want string }{
+		{"<html><body><p>Hi</p></body></html>", "<html><body><p>Hi</p><script></script></body></html>"},
+		{"<p>Hi</p>", "<p>Hi</p><script></script>"},
+	}
+	for _, tt := range tests {
+		if got := string(injectScript([]byte(tt.page), script)); got != tt.want {
+			t.Errorf("want %q, got %q", tt.want, got)
+		}
+	}
+}
+
+// readEvent returns the first event name sent on the stream.
+func readEvent(t *testing.T, url string) chan string {
+	events := make(chan string, 1)
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer resp.Body.Close()
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if strings.HasPrefix(sc.Text(), "event: ") {
+				events <- strings.TrimPrefix(sc.Text(), "event: ")
+				return
+			}
+		}
+		close(events)
+	}()
+	return events
+}
+
+func TestDevReloader(t *testing.T) {
+	d := newDevReloader()
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	events := readEvent(t, srv.URL+"?since=0")
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event before changes: %q", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+	d.changed()
+	select {
+	case e := <-events:
+		if e != "reload" {
+			t.Errorf("want reload event, got %q", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reload event")
+	}
+
+	// Pages rendered before the last change reload immediately.
+	select {
+	case e := <-readEvent(t, srv.URL+"?since=0"):
+		if e != "reload" {
+			t.Errorf("want reload event, got %q", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reload event")
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"presence/theme"
+	"regexp"
+	"strings"
+)
+
+// Export renders every route served by the site into dir, so that it can be
+// hosted as a static website. Routes without a file extension are written as
+// index.html files in their own directories, and root-relative links in HTML
+// documents are rewritten to relative ones.
+func (s *Server) Export(dir string) error {
+	router := s.newRouter()
+
+	for _, route := range s.exportRoutes() {
+		if err := s.exportRoute(router, dir, route); err != nil {
+			return fmt.Errorf("couldn't export '%s': %v", route, err)
+		}
+	}
+
+	static := theme.Layer(s.app.Config().StaticDir, theme.Static)
+	if err := copyDir(static, filepath.Join(dir, "static")); err != nil {
+		return fmt.Errorf("couldn't copy static files: %v", err)
+	}
+
+	// The media dir is only created on the first upload.
+	if _, err := os.Stat(s.app.Config().MediaDir); s.app.Config().MediaDir != "" && err == nil {
+		dst := filepath.Join(dir, "media")
+		if err := copyDir(os.DirFS(s.app.Config().MediaDir), dst); err != nil {
+			return fmt.Errorf("couldn't copy media files: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// exportRoutes returns the URL paths of all the dynamic routes.
+func (s *Server) exportRoutes() []string {
+	routes := []string{
+		"/", "/archive", "/tags", "/rss.xml", "/atom.xml", "/feed.json",
+		"/sitemap.xml", "/robots.txt",
+	}
+
+	limit := int(s.app.Config().MaxEntriesPerPage)
+	for page := 2; limit > 0 && limit*(page-1) < s.app.PostCount(); page++ {
+		routes = append(routes, fmt.Sprintf("/%d/", page))
+	}
+	for _, a := range s.app.GetAllPosts() {
+		routes = append(routes, "/"+a.Slug, "/"+a.Slug+".md", "/"+a.Slug+".txt")
+	}
+	for _, a := range s.app.GetAllPages() {
+		routes = append(routes, "/"+a.Slug, "/"+a.Slug+".md", "/"+a.Slug+".txt")
+	}
+	for name := range s.app.GetTags() {
+		u := newTagData(name, 0).URL
+		routes = append(routes, u, u+"/rss.xml", u+"/atom.xml", u+"/feed.json")
+	}
+	if n := len(s.sitemapURLs()); n > maxSitemapURLs {
+		for i := 1; i <= sitemapCount(n); i++ {
+			routes = append(routes, fmt.Sprintf("/sitemap-%d.xml", i))
+		}
+	}
+
+	return routes
+}
+
+func (s *Server) exportRoute(h http.Handler, dir, route string) error {
+	r := httptest.NewRequest("GET", route, nil)
+	r.Host = s.app.Config().Host
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		return fmt.Errorf("unexpected status: %d", w.Code)
+	}
+
+	body := w.Body.Bytes()
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		body = relativizeLinks(body, route)
+	}
+
+	fp := filepath.Join(dir, filepath.FromSlash(exportPath(r.URL.Path)))
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fp, body, 0644)
+}
+
+// exportPath maps the URL path to the path of the exported file.
+func exportPath(urlPath string) string {
+	if exportedAsFile(urlPath) {
+		return urlPath
+	}
+	return path.Join(urlPath, "index.html")
+}
+
+// exportedAsFile reports whether the URL path is exported as a file of the
+// same name, rather than as an index.html file in its own directory. That's
+// the case of the paths with an extension, except the tag pages, as tags may
+// contain dots.
+func exportedAsFile(urlPath string) bool {
+	return path.Ext(urlPath) != "" && path.Dir(urlPath) != "/tag"
+}
+
+// exportDir returns the directory containing the exported file for the URL
+// path, with a trailing slash.
+func exportDir(urlPath string) string {
+	dir := path.Dir(exportPath(urlPath))
+	if dir == "/" {
+		return dir
+	}
+	return dir + "/"
+}
+
+var reRootRelativeLink = regexp.MustCompile(`(href|src)="(/[^/"][^"]*|/)"`)
+
+// relativizeLinks rewrites root-relative links in the HTML document served
+// at route so that they're relative to the document's location.
+func relativizeLinks(html []byte, route string) []byte {
+	from := exportDir(route)
+	return reRootRelativeLink.ReplaceAllFunc(html, func(m []byte) []byte {
+		sub := reRootRelativeLink.FindSubmatch(m)
+		attr, link := string(sub[1]), string(sub[2])
+		u, err := url.Parse(link)
+		if err != nil {
+			return m
+		}
+		target := u.EscapedPath()
+		if !exportedAsFile(target) {
+			target = exportDir(target)
+		}
+		rel, err := filepath.Rel(filepath.FromSlash(from), filepath.FromSlash(target))
+		if err != nil {
+			return m
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasSuffix(target, "/") {
+			rel += "/"
+		}
+		u.Path, u.RawPath = "", ""
+		return []byte(fmt.Sprintf(`%s="%s%s"`, attr, rel, u.String()))
+	})
+}
+
+// copyDir recursively copies the files in fsys to dst.
+func copyDir(fsys fs.FS, dst string) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, filepath.FromSlash(name))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return theme.CopyFile(fsys, name, target)
+	})
+}
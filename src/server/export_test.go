@@ -0,0 +1,87 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelativizeLinks(t *testing.T) {
+	tests := []struct {
+		route string
+		html  string
+		want  string
+	}{
+		{"/", `<a href="/">`, `<a href="./">`},
+		{"/", `<a href="/archive">`, `<a href="archive/">`},
+		{"/", `<a href="/2/">`, `<a href="2/">`},
+		{"/2/", `<a href="/">`, `<a href="../">`},
+		{"/hello-world", `<a href="/archive">`, `<a href="../archive/">`},
+		{"/hello-world", `<link href="/static/css/style.css">`, `<link href="../static/css/style.css">`},
+		{"/hello-world", `<a href="/rss.xml">`, `<a href="../rss.xml">`},
+		{"/tag/go", `<a href="/tag/go/rss.xml">`, `<a href="rss.xml">`},
+		{"/tag/go", `<a href="/hello-world#top">`, `<a href="../../hello-world/#top">`},
+		{"/tag/go", `<a href="/tag/go%20web">`, `<a href="../go%20web/">`},
+		{"/tag/go", `<a href="/tag/node.js">`, `<a href="../node.js/">`},
+		{"/tag/node.js", `<a href="/tag/node.js/rss.xml">`, `<a href="rss.xml">`},
+		{"/", `<a href="//example.org/">`, `<a href="//example.org/">`},
+		{"/", `<a href="https://example.org/">`, `<a href="https://example.org/">`},
+	}
+
+	for _, tt := range tests {
+		got := string(relativizeLinks([]byte(tt.html), tt.route))
+		if got != tt.want {
+			t.Errorf("%s: %s: want %s, got %s", tt.route, tt.html, tt.want, got)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"first.946728000.md":  "---\ntags: [go, node.js, CI/CD]\n---\n# First",
+		"second.946814400.md": "# Second",
+		"third.946900800.md":  "# Third",
+	})
+	s.app.Config().MaxEntriesPerPage = 2
+	if err := s.initTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "presence_test_export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		"index.html", "2/index.html", "archive/index.html", "tags/index.html",
+		"first/index.html", "first.md", "first.txt", "third/index.html",
+		"rss.xml", "atom.xml", "feed.json", "sitemap.xml", "robots.txt",
+		"tag/go/index.html", "tag/go/rss.xml", "static/css/style.css",
+		"tag/node.js/index.html", "tag/node.js/feed.json", "tag/ci-cd/index.html",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s not exported: %v", name, err)
+		}
+	}
+	// Search needs a server to answer the queries.
+	for _, name := range []string{"3", "search"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("unexpected %s: %v", name, err)
+		}
+	}
+
+	// Links are relative to the exported file.
+	b, err := ioutil.ReadFile(filepath.Join(dir, "2", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page := string(b); !strings.Contains(page, `href="../first/"`) || strings.Contains(page, `href="/first"`) {
+		t.Errorf("links not relativized in page 2:\n%s", page)
+	}
+}
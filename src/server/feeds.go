@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"presence/model"
+	"presence/store"
+	"strings"
+	"time"
+
+	"github.com/gorilla/feeds"
+)
+
+// handleFeed serves the feed of the most recent posts in the format given by
+// the route.
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	posts := s.app.GetRecentPosts(0, int(s.app.Config().FeedItems))
+	feed := s.newFeed(s.app.Config().Title, s.BaseURL(), posts)
+	s.writeFeed(w, feed, path.Base(r.URL.Path))
+}
+
+func (s *Server) newFeed(title, link string, posts []*model.Article) *feeds.Feed {
+	feed := &feeds.Feed{
+		Title:       title,
+		Link:        &feeds.Link{Href: link},
+		Description: s.app.Config().Description,
+		Author:      &feeds.Author{Name: s.app.Config().Author},
+	}
+
+	items := make([]*feeds.Item, 0, len(posts))
+	for _, p := range posts {
+		item := &feeds.Item{
+			Id:          s.absURL("/" + p.Slug),
+			Title:       p.Title,
+			Link:        &feeds.Link{Href: s.absURL("/" + p.Slug)},
+			Author:      &feeds.Author{Name: s.app.Config().Author},
+			Description: summarize(p),
+			Created:     *p.PubTime,
+			Updated:     *p.PubTime,
+		}
+		if p.Author != "" {
+			item.Author.Name = p.Author
+		}
+		if p.Updated != nil {
+			item.Updated = *p.Updated
+		}
+		if s.app.Config().FeedFullContent {
+			item.Content = p.BodyHTML
+		}
+		if item.Updated.After(feed.Updated) {
+			feed.Updated = item.Updated
+		}
+		items = append(items, item)
+	}
+
+	feed.Items = items
+	return feed
+}
+
+// summarize returns the description of the article, or its first paragraph
+// if the description is not set.
+func summarize(a *model.Article) string {
+	if a.Description != "" {
+		return a.Description
+	}
+	html := a.BodyHTML
+	if i := strings.Index(html, "</p>"); i >= 0 {
+		return html[:i+len("</p>")]
+	}
+	return html
+}
+
+// writeFeed writes the feed to w in the format identified by the filename
+// (rss.xml, atom.xml or feed.json).
+func (s *Server) writeFeed(w http.ResponseWriter, feed *feeds.Feed, filename string) {
+	var contentType, body string
+	var err error
+
+	switch filename {
+	case "atom.xml":
+		contentType = "application/atom+xml; charset=utf-8"
+		body, err = feed.ToAtom()
+	case "feed.json":
+		contentType = "application/feed+json; charset=utf-8"
+		body, err = s.toJSONFeed(feed)
+	default:
+		contentType = "application/rss+xml; charset=utf-8"
+		body, err = feed.ToRss()
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(body))
+}
+
+// jsonFeed represents a JSON Feed 1.1 document. The JSON Feed support in
+// gorilla/feeds is limited to version 1.0.
+type jsonFeed struct {
+	Version     string          `json:"version"`
+	Title       string          `json:"title"`
+	HomePageURL string          `json:"home_page_url,omitempty"`
+	FeedURL     string          `json:"feed_url,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Authors     []*jsonAuthor   `json:"authors,omitempty"`
+	Items       []*jsonFeedItem `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name,omitempty"`
+}
+
+type jsonFeedItem struct {
+	ID            string        `json:"id"`
+	URL           string        `json:"url,omitempty"`
+	Title         string        `json:"title,omitempty"`
+	ContentHTML   string        `json:"content_html,omitempty"`
+	Summary       string        `json:"summary,omitempty"`
+	DatePublished string        `json:"date_published,omitempty"`
+	DateModified  string        `json:"date_modified,omitempty"`
+	Authors       []*jsonAuthor `json:"authors,omitempty"`
+}
+
+// stripTags converts the HTML fragment to plain text. The summary field in
+// JSON Feed is plain text.
+func stripTags(s string) string {
+	return strings.TrimSpace(store.HTMLText(s, ""))
+}
+
+func (s *Server) toJSONFeed(feed *feeds.Feed) (string, error) {
+	jf := &jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.Link.Href,
+		FeedURL:     strings.TrimSuffix(feed.Link.Href, "/") + "/feed.json",
+		Description: feed.Description,
+		Authors:     []*jsonAuthor{{Name: feed.Author.Name}},
+		Items:       make([]*jsonFeedItem, 0, len(feed.Items)),
+	}
+
+	for _, item := range feed.Items {
+		ji := &jsonFeedItem{
+			ID:            item.Id,
+			URL:           item.Link.Href,
+			Title:         item.Title,
+			ContentHTML:   item.Content,
+			Summary:       stripTags(item.Description),
+			DatePublished: item.Created.Format(time.RFC3339),
+			DateModified:  item.Updated.Format(time.RFC3339),
+			Authors:       []*jsonAuthor{{Name: item.Author.Name}},
+		}
+		// content_html is required if there's no content_text.
+		if ji.ContentHTML == "" {
+			ji.ContentHTML = item.Description
+		}
+		jf.Items = append(jf.Items, ji)
+	}
+
+	data, err := json.MarshalIndent(jf, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var feedPosts = map[string]string{
+	"old.946728000.md":       "# Old\n\nOld post.",
+	"new.946814400.md":       "---\nauthor: Ann\nupdated: 2000-01-03T12:00:00Z\n---\n# New\n\nFirst <em>paragraph</em>.\n\nSecond paragraph.",
+	"described.946900800.md": "---\ndescription: A short description\n---\n# Described\n\nBody.",
+}
+
+func TestFeedFormats(t *testing.T) {
+	s := newTestServer(t, feedPosts)
+	s.app.Config().Author = "Site Author"
+	s.app.Config().FeedItems = 2
+
+	tests := []struct {
+		target      string
+		contentType string
+		want        []string
+	}{
+		{"/rss.xml", "application/rss+xml; charset=utf-8", []string{
+			"<title>Described</title>", "<title>New</title>",
+			"<description>A short description</description>",
+			"<description>&lt;p&gt;First &lt;em&gt;paragraph&lt;/em&gt;.&lt;/p&gt;</description>",
+			"<author>Ann</author>",
+		}},
+		{"/atom.xml", "application/atom+xml; charset=utf-8", []string{
+			"<title>Described</title>", "<title>New</title>",
+			"<updated>2000-01-03T12:00:00Z</updated>",
+			"<name>Ann</name>", "<name>Site Author</name>",
+		}},
+		{"/feed.json", "application/feed+json; charset=utf-8", []string{
+			`"title": "Described"`, `"title": "New"`,
+			`"summary": "A short description"`,
+			`"summary": "First paragraph."`,
+			`"date_modified": "2000-01-03T12:00:00Z"`,
+			`"name": "Ann"`,
+		}},
+	}
+	for _, tt := range tests {
+		w := get(s.handleFeed, tt.target)
+		if w.Code != 200 {
+			t.Fatalf("%s: unexpected status %d", tt.target, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s: want content type %q, got %q", tt.target, tt.contentType, ct)
+		}
+		body := w.Body.String()
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: missing %s in:\n%s", tt.target, want, body)
+			}
+		}
+		// feed_items limits the entries to the most recent posts.
+		if strings.Contains(body, "Old") {
+			t.Errorf("%s: want 2 entries, got the oldest post too", tt.target)
+		}
+	}
+}
+
+func TestFeedFullContent(t *testing.T) {
+	s := newTestServer(t, feedPosts)
+	s.app.Config().FeedFullContent = true
+
+	w := get(s.handleFeed, "/feed.json")
+	var feed jsonFeed
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 3 {
+		t.Fatalf("want 3 items, got %d", len(feed.Items))
+	}
+	item := feed.Items[1]
+	if item.Title != "New" || !strings.Contains(item.ContentHTML, "Second paragraph.") || item.Summary != "First paragraph." {
+		t.Errorf("unexpected item: %+v", item)
+	}
+
+	w = get(s.handleFeed, "/atom.xml")
+	if body := w.Body.String(); !strings.Contains(body, "Second paragraph.") {
+		t.Errorf("atom: want the full content in:\n%s", body)
+	}
+
+	// Without full content, only the summary is included.
+	s.app.Config().FeedFullContent = false
+	w = get(s.handleFeed, "/feed.json")
+	if body := w.Body.String(); strings.Contains(body, "Second paragraph.") {
+		t.Errorf("want the summary only, got:\n%s", body)
+	}
+}
+
+func TestFeedLinks(t *testing.T) {
+	s := newTestServer(t, feedPosts)
+	if err := s.initTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	w := get(s.handleHome, "/")
+	for _, want := range []string{
+		`<link rel="alternate" title="Test" type="application/rss+xml" href="/rss.xml" />`,
+		`<link rel="alternate" title="Test" type="application/atom+xml" href="/atom.xml" />`,
+		`<link rel="alternate" title="Test" type="application/feed+json" href="/feed.json" />`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+func TestStripTags(t *testing.T) {
+	tests := []struct{ html, want string }{
+		{"<p>Hello, <em>world</em>!</p>", "Hello, world!"},
+		{"<p>Fish &amp; chips</p>\n", "Fish & chips"},
+		{"plain", "plain"},
+	}
+	for _, tt := range tests {
+		if got := stripTags(tt.html); got != tt.want {
+			t.Errorf("%q: want %q, got %q", tt.html, tt.want, got)
+		}
+	}
+}
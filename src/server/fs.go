@@ -25,3 +25,9 @@ func (fs FileSystem) Open(path string) (http.File, error) {
 	}
 	return f, nil
 }
+
+// dirExists reports whether path is an existing directory.
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
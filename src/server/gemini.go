@@ -0,0 +1,144 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"presence/gemini"
+	"presence/model"
+	"strings"
+	"time"
+)
+
+func (s *Server) newGeminiServer(config *tls.Config) *gemini.Server {
+	return &gemini.Server{
+		Addr:      fmt.Sprintf(":%d", s.app.Config().GeminiPort),
+		TLSConfig: config,
+		Handler:   s.withGeminiLogging(s.handleGemini),
+	}
+}
+
+func (s *Server) withGeminiLogging(next gemini.Handler) gemini.Handler {
+	return func(r *gemini.Request) *gemini.Response {
+		timeStart := time.Now()
+		resp := next(r)
+		s.accessLog.Printf("%v GEMINI %v %v (%v)\n", r.RemoteAddr, r.URL, resp.Status, time.Since(timeStart))
+		return resp
+	}
+}
+
+// handleGemini serves the home page, the archive, and the posts and pages
+// as gemtext.
+func (s *Server) handleGemini(r *gemini.Request) *gemini.Response {
+	if !strings.EqualFold(r.URL.Hostname(), s.app.Config().Host) {
+		return gemini.Error(gemini.StatusProxyRequestRefused, "proxy request refused")
+	}
+	switch r.URL.Path {
+	case "/":
+		return gemini.Document(s.geminiHome())
+	case "/archive":
+		return gemini.Document(s.geminiArchive())
+	}
+	a := s.articleAt(r.URL.Path)
+	if a == nil {
+		return gemini.Error(gemini.StatusNotFound, "not found")
+	}
+	return gemini.Document(s.geminiRenderArticle(a))
+}
+
+// articleAt returns the post or page served at the path, if any.
+func (s *Server) articleAt(path string) *model.Article {
+	slug := strings.TrimPrefix(path, "/")
+	if a := s.app.GetPage(slug); a != nil {
+		return a
+	}
+	return s.app.GetPost(slug)
+}
+
+// geminiLink returns the link destination to use in gemtext: root-relative
+// links to anything not served over Gemini, such as static files, point to
+// the website.
+func (s *Server) geminiLink(dest string) string {
+	if !strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "//") {
+		return dest
+	}
+	path := dest
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if path == "/" || path == "/archive" || s.articleAt(path) != nil {
+		return dest
+	}
+	return s.absURL(dest)
+}
+
+// articleTitle returns the title of the article, or its slug if it has none.
+func articleTitle(a *model.Article) string {
+	if a.Title != "" {
+		return a.Title
+	}
+	return a.Slug
+}
+
+// geminiPostLink returns the link line to the post, in the format Gemini
+// clients recognize to subscribe to the page.
+func geminiPostLink(a *model.Article) string {
+	return fmt.Sprintf("=> /%s %s %s\n", a.Slug, a.PubTime.Format(textDateFormat), articleTitle(a))
+}
+
+func (s *Server) geminiHome() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", s.app.Config().Title)
+	if s.app.Config().Description != "" {
+		fmt.Fprintf(&b, "%s\n\n", s.app.Config().Description)
+	}
+	if pages := s.app.GetAllPages(); len(pages) > 0 {
+		for _, a := range pages {
+			fmt.Fprintf(&b, "=> /%s %s\n", a.Slug, articleTitle(a))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("## Posts\n\n")
+	for _, a := range s.app.GetRecentPosts(0, int(s.app.Config().MaxEntriesPerPage)) {
+		b.WriteString(geminiPostLink(a))
+	}
+	b.WriteString("\n=> /archive Archive\n")
+	return []byte(b.String())
+}
+
+func (s *Server) geminiArchive() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Archive\n")
+	var current int
+	for _, a := range s.app.GetAllPosts() {
+		if y := a.PubTime.Year(); y != current {
+			fmt.Fprintf(&b, "\n## %d\n\n", y)
+			current = y
+		}
+		b.WriteString(geminiPostLink(a))
+	}
+	b.WriteString("\n=> / Home\n")
+	return []byte(b.String())
+}
+
+func (s *Server) geminiRenderArticle(a *model.Article) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", articleTitle(a))
+	// Pages have no publication date to show.
+	if s.app.GetPage(a.Slug) == nil && a.PubTime != nil {
+		fmt.Fprintf(&b, "%s\n\n", a.PubTime.Format(textDateFormat))
+	}
+	if body := gemini.Gemtext(a.BodyMarkdown, s.geminiLink); len(body) > 0 {
+		b.Write(body)
+		b.WriteString("\n")
+	}
+	if len(a.Tags) > 0 {
+		tags := make([]string, len(a.Tags))
+		for i, t := range a.Tags {
+			tags[i] = "#" + t
+		}
+		fmt.Fprintf(&b, "Tags: %s\n\n", strings.Join(tags, " "))
+	}
+	fmt.Fprintf(&b, "=> %s View on the web\n", s.absURL("/"+a.Slug))
+	b.WriteString("=> / Home\n")
+	return []byte(b.String())
+}
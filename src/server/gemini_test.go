@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/url"
+	"presence/gemini"
+	"presence/logger"
+	"strings"
+	"testing"
+)
+
+func TestGemini(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"old.946684800.md":  "---\ntags: [misc]\n---\n# Old\n\nSee [the new post](/new) and [the logo](/static/logo.png).",
+		"new.1609459200.md": "# New\n\nHi.",
+	})
+	s.accessLog = logger.NewLogger()
+
+	get := func(target string) *gemini.Response {
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.withGeminiLogging(s.handleGemini)(&gemini.Request{URL: u, RemoteAddr: "127.0.0.1:1234"})
+	}
+
+	tests := []struct {
+		target string
+		status int
+		lines  []string
+	}{
+		{"gemini://example.org/", 20, []string{
+			"# Test",
+			"=> /new 2021-01-01 New",
+			"=> /old 2000-01-01 Old",
+			"=> /archive Archive",
+		}},
+		{"gemini://example.org/archive", 20, []string{"## 2021", "## 2000", "=> /old 2000-01-01 Old"}},
+		{"gemini://example.org/old", 20, []string{
+			"# Old",
+			"2000-01-01",
+			"See the new post and the logo.",
+			"=> /new the new post",
+			"=> http://example.org/static/logo.png the logo",
+			"Tags: #misc",
+			"=> http://example.org/old View on the web",
+		}},
+		{"gemini://example.org/missing", 51, nil},
+		{"gemini://other.org/", 53, nil},
+	}
+	for _, tt := range tests {
+		resp := get(tt.target)
+		if resp.Status != tt.status {
+			t.Errorf("%s: want status %d, got %d", tt.target, tt.status, resp.Status)
+			continue
+		}
+		lines := strings.Split(string(resp.Body), "\n")
+		for _, want := range tt.lines {
+			found := false
+			for _, line := range lines {
+				found = found || line == want
+			}
+			if !found {
+				t.Errorf("%s: missing line %q in:\n%s", tt.target, want, resp.Body)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"presence/gopher"
+	"presence/model"
+	"strings"
+	"time"
+)
+
+func (s *Server) newGopherServer() *gopher.Server {
+	return &gopher.Server{
+		Addr:    fmt.Sprintf(":%d", s.app.Config().GopherPort),
+		Handler: s.withGopherLogging(s.handleGopher),
+	}
+}
+
+func (s *Server) withGopherLogging(next gopher.Handler) gopher.Handler {
+	return func(r *gopher.Request) []byte {
+		timeStart := time.Now()
+		resp := next(r)
+		s.accessLog.Printf("%v GOPHER %q %v (%v)\n", r.RemoteAddr, r.Selector, len(resp), time.Since(timeStart))
+		return resp
+	}
+}
+
+// handleGopher serves the menus of the home page and the archive, and the
+// posts and pages as text files.
+func (s *Server) handleGopher(r *gopher.Request) []byte {
+	switch r.Selector {
+	case "", "/":
+		return gopher.Menu(s.gopherHome())
+	case "/archive":
+		return gopher.Menu(s.gopherArchive())
+	}
+	a := s.articleAt(r.Selector)
+	if a == nil {
+		return gopher.Error("not found")
+	}
+	return gopher.TextFile(s.gopherRenderArticle(a))
+}
+
+// gopherItem returns the menu item for the selector on this server.
+func (s *Server) gopherItem(typ byte, display, selector string) gopher.Item {
+	return gopher.Item{
+		Type:     typ,
+		Display:  display,
+		Selector: selector,
+		Host:     s.app.Config().Host,
+		Port:     s.app.Config().GopherPort,
+	}
+}
+
+func (s *Server) gopherPostItem(a *model.Article) gopher.Item {
+	display := a.PubTime.Format(textDateFormat) + " " + articleTitle(a)
+	return s.gopherItem(gopher.TypeText, display, "/"+a.Slug)
+}
+
+func (s *Server) gopherHome() []gopher.Item {
+	items := []gopher.Item{gopher.Info(s.app.Config().Title)}
+	if s.app.Config().Description != "" {
+		items = append(items, gopher.Info(s.app.Config().Description))
+	}
+	items = append(items, gopher.Info(""))
+	if pages := s.app.GetAllPages(); len(pages) > 0 {
+		for _, a := range pages {
+			items = append(items, s.gopherItem(gopher.TypeText, articleTitle(a), "/"+a.Slug))
+		}
+		items = append(items, gopher.Info(""))
+	}
+	items = append(items, gopher.Info("Posts"), gopher.Info(""))
+	for _, a := range s.app.GetRecentPosts(0, int(s.app.Config().MaxEntriesPerPage)) {
+		items = append(items, s.gopherPostItem(a))
+	}
+	items = append(items,
+		gopher.Info(""),
+		s.gopherItem(gopher.TypeMenu, "Archive", "/archive"),
+		s.gopherItem(gopher.TypeHTML, "Website", "URL:"+s.absURL("/")),
+	)
+	return items
+}
+
+func (s *Server) gopherArchive() []gopher.Item {
+	items := []gopher.Item{gopher.Info("Archive")}
+	var current int
+	for _, a := range s.app.GetAllPosts() {
+		if y := a.PubTime.Year(); y != current {
+			items = append(items, gopher.Info(""), gopher.Info(fmt.Sprint(y)))
+			current = y
+		}
+		items = append(items, s.gopherPostItem(a))
+	}
+	return append(items, gopher.Info(""), s.gopherItem(gopher.TypeMenu, "Home", "/"))
+}
+
+// gopherLink returns the destination of the link for the text file: links
+// to posts and pages point to their text over Gopher, and other root-relative
+// links to the website.
+func (s *Server) gopherLink(dest string) string {
+	if !strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "//") {
+		return dest
+	}
+	if a := s.articleAt(strings.SplitN(dest, "#", 2)[0]); a != nil {
+		host := s.app.Config().Host
+		if port := s.app.Config().GopherPort; port != 70 {
+			host = fmt.Sprintf("%s:%d", host, port)
+		}
+		return fmt.Sprintf("gopher://%s/0/%s", host, a.Slug)
+	}
+	return s.absURL(dest)
+}
+
+func (s *Server) gopherRenderArticle(a *model.Article) string {
+	return s.articleText(a, s.gopherLink) + fmt.Sprintf("Web: %s\n", s.absURL("/"+a.Slug))
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"presence/gopher"
+	"presence/logger"
+	"strings"
+	"testing"
+)
+
+func TestGopher(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"old.946684800.md":  "---\ntags: [misc]\n---\n# Old\n\nSee [the new post](/new) and [the logo](/static/logo.png).",
+		"new.1609459200.md": "# New\n\nHi.",
+	})
+	s.accessLog = logger.NewLogger()
+	s.app.Config().GopherPort = 7070
+
+	tests := []struct {
+		selector string
+		lines    []string
+	}{
+		{"", []string{
+			"iTest\t\terror.host\t1",
+			"02021-01-01 New\t/new\texample.org\t7070",
+			"02000-01-01 Old\t/old\texample.org\t7070",
+			"1Archive\t/archive\texample.org\t7070",
+			"hWebsite\tURL:http://example.org/\texample.org\t7070",
+		}},
+		{"/archive", []string{"i2021\t\terror.host\t1", "i2000\t\terror.host\t1"}},
+		{"/old", []string{
+			"Old",
+			"===",
+			"2000-01-01",
+			"See the new post [1] and the logo [2].",
+			"[1] gopher://example.org:7070/0/new",
+			"[2] http://example.org/static/logo.png",
+			"Tags: misc",
+			"Web: http://example.org/old",
+		}},
+		{"/missing", []string{"3not found\t\terror.host\t1"}},
+	}
+	for _, tt := range tests {
+		resp := s.withGopherLogging(s.handleGopher)(&gopher.Request{Selector: tt.selector, RemoteAddr: "127.0.0.1:1234"})
+		lines := strings.Split(string(resp), "\r\n")
+		if lines[len(lines)-2] != "." {
+			t.Errorf("%q: missing terminator in:\n%s", tt.selector, resp)
+		}
+		for _, want := range tt.lines {
+			found := false
+			for _, line := range lines {
+				found = found || line == want
+			}
+			if !found {
+				t.Errorf("%q: missing line %q in:\n%s", tt.selector, want, resp)
+			}
+		}
+	}
+}
@@ -1,42 +1,67 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"path"
+	"presence/activitypub"
 	"presence/model"
+	"presence/preview"
+	"presence/store"
+	"sort"
 	"strconv"
+	"time"
 
-	"github.com/gorilla/feeds"
 	"github.com/gorilla/mux"
 	"github.com/lestrrat-go/strftime"
 )
 
 type articleData struct {
-	Slug  string
-	Title string
-	Date  string
-	URL   string
-	Body  template.HTML
+	Slug        string
+	Title       string
+	Description string
+	Date        string
+	Updated     string
+	Tags        []*tagData
+	Draft       bool
+	Aliases     []string
+	Params      map[string]interface{}
+	URL         string
+	Body        template.HTML
+}
+
+func (s *Server) formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	d, err := strftime.Format(s.app.Config().DateFormat, *t)
+	if err != nil {
+		panic(err)
+	}
+	return d
 }
 
 func (s *Server) newArticleData(a *model.Article) *articleData {
-	var date string
-	if a.PubTime != nil {
-		if d, err := strftime.Format(s.app.Config.DateFormat, *a.PubTime); err != nil {
-			panic(err)
-		} else {
-			date = d
-		}
+	tags := make([]*tagData, 0, len(a.Tags))
+	for _, t := range a.Tags {
+		tags = append(tags, newTagData(t, 0))
 	}
 	return &articleData{
-		Slug:  a.Slug,
-		Title: a.Title,
-		Date:  date,
-		URL:   path.Join(s.BaseURL(), a.Slug),
-		Body:  template.HTML(a.BodyHTML),
+		Slug:        a.Slug,
+		Title:       a.Title,
+		Description: a.Description,
+		Date:        s.formatDate(a.PubTime),
+		Updated:     s.formatDate(a.Updated),
+		Tags:        tags,
+		Draft:       a.Draft,
+		Aliases:     a.Aliases,
+		Params:      a.Params,
+		URL:         s.absURL("/" + a.Slug),
+		Body:        template.HTML(a.BodyHTML),
 	}
 }
 
@@ -54,16 +79,56 @@ type commonData struct {
 	Author      string
 	Description string
 	Pages       []*articleData
+	Micropub    string // endpoint advertised to Micropub clients
+
+	// IndieAuth endpoints, from which Micropub clients get their tokens.
+	AuthorizationEndpoint string
+	TokenEndpoint         string
 }
 
 func (s *Server) newCommonData(r *http.Request) *commonData {
-	return &commonData{
+	data := &commonData{
 		Path:        r.URL.Path,
-		Title:       s.app.Config.Title,
-		Author:      s.app.Config.Author,
-		Description: s.app.Config.Description,
+		Title:       s.app.Config().Title,
+		Author:      s.app.Config().Author,
+		Description: s.app.Config().Description,
 		Pages:       s.newArticleDataSlice(s.app.GetAllPages()),
 	}
+	if s.micropubEnabled() {
+		data.Micropub = s.absURL("/micropub")
+	}
+	if s.indieAuthEnabled() {
+		data.AuthorizationEndpoint = s.app.Config().AuthorizationEndpoint
+		data.TokenEndpoint = s.app.Config().TokenEndpoint
+	}
+	return data
+}
+
+// render executes the named template with data and writes the result to w.
+func (s *Server) render(w http.ResponseWriter, tname string, data interface{}) {
+	t, ok := s.template(tname)
+	if !ok {
+		log.Println("couldn't load template: " + tname)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if s.dev == nil {
+		if err := t.Execute(w, data); err != nil {
+			log.Println(err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// In dev mode, the page is buffered to add the reload script.
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		log.Println(err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(injectScript(buf.Bytes(), s.dev.script()))
 }
 
 func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
@@ -76,7 +141,7 @@ func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	limit := int(s.app.Config.MaxEntriesPerPage)
+	limit := int(s.app.Config().MaxEntriesPerPage)
 	posts := s.app.GetRecentPosts(limit*(page-1), limit)
 	if page != 1 && len(posts) == 0 {
 		http.Error(w, "page not found", 404)
@@ -100,23 +165,20 @@ func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
 		next,
 	}
 
-	tname := "home.html"
-	t, ok := s.templates[tname]
-	if !ok {
-		log.Println("couldn't load template: " + tname)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	if err := t.Execute(w, data); err != nil {
-		log.Println(err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
-	}
+	s.render(w, "home.html", data)
 }
 
 func (s *Server) handleArticle(w http.ResponseWriter, r *http.Request) {
 	slug := mux.Vars(r)["slug"]
 
+	// The Markdown source, and the ActivityPub object of posts, are served
+	// at the same URL.
+	w.Header().Add("Vary", "Accept")
+	if acceptsMarkdown(r) {
+		s.handleArticleMarkdown(w, r)
+		return
+	}
+
 	article := s.app.GetPage(slug)
 	if article == nil {
 		article = s.app.GetPost(slug)
@@ -124,32 +186,68 @@ func (s *Server) handleArticle(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "not found", 404)
 			return
 		}
+		// Fediverse servers fetch posts by their ActivityPub ID.
+		f := s.app.Federation()
+		if f != nil && activitypub.Accepts(r) {
+			obj := f.Site.Article(article)
+			obj.Context = "https://www.w3.org/ns/activitystreams"
+			writeActivityJSON(w, activitypub.ContentType, obj)
+			return
+		}
 	} else {
 		// For pages, PubTime is only used for sorting and shouldn't be displayed
-		// to visitors.
-		article.PubTime = nil
+		// to visitors. The page is shared through the store, so it's changed on
+		// a copy.
+		page := *article
+		page.PubTime = nil
+		article = &page
 	}
 
+	s.renderArticle(w, r, article)
+}
+
+func (s *Server) renderArticle(w http.ResponseWriter, r *http.Request, article *model.Article) {
 	data := struct {
 		*commonData
-		Article *articleData
+		Article     *articleData
+		Webmentions *webmentionsData
 	}{
 		s.newCommonData(r),
 		s.newArticleData(article),
+		s.newWebmentionsData(article.Slug),
 	}
 
-	tname := "article.html"
-	t, ok := s.templates[tname]
-	if !ok {
-		log.Println("couldn't load template: " + tname)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+	s.render(w, s.articleTemplate(article), data)
+}
+
+// handlePreview renders an unpublished article, given a valid preview token.
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	secret := s.app.Config().PreviewSecret
+	if secret == "" {
+		http.Error(w, "not found", 404)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if err := preview.Verify(secret, slug, token, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
 
-	if err := t.Execute(w, data); err != nil {
-		log.Println(err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+	article := s.app.GetUnpublished(slug)
+	if article == nil {
+		if s.app.GetPost(slug) != nil || s.app.GetPage(slug) != nil {
+			http.Redirect(w, r, "/"+slug, http.StatusFound)
+			return
+		}
+		http.Error(w, "not found", 404)
+		return
 	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	s.renderArticle(w, r, article)
 }
 
 type yearData struct {
@@ -180,47 +278,79 @@ func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
 		years,
 	}
 
-	tname := "archive.html"
-	t, ok := s.templates[tname]
-	if !ok {
-		log.Println("couldn't load template: " + tname)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
-		return
-	}
+	s.render(w, "archive.html", data)
+}
 
-	if err := t.Execute(w, data); err != nil {
-		log.Println(err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+type tagData struct {
+	Name  string
+	Count int
+	URL   string
+}
+
+func newTagData(name string, count int) *tagData {
+	name = store.NormalizeTag(name)
+	return &tagData{
+		Name:  name,
+		Count: count,
+		URL:   "/tag/" + url.PathEscape(name),
 	}
 }
 
-func (s *Server) handleRSS(w http.ResponseWriter, r *http.Request) {
-	feed := &feeds.Feed{
-		Title:       s.app.Config.Title,
-		Link:        &feeds.Link{Href: s.BaseURL()},
-		Description: s.app.Config.Description,
-		Author:      &feeds.Author{Name: s.app.Config.Author},
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	counts := s.app.GetTags()
+	tags := make([]*tagData, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, newTagData(name, count))
 	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
 
-	maxItems := 25
-	items := make([]*feeds.Item, 0, maxItems)
-	posts := s.app.GetRecentPosts(0, maxItems)
+	data := struct {
+		*commonData
+		Tags []*tagData
+	}{
+		s.newCommonData(r),
+		tags,
+	}
 
-	for _, p := range posts {
-		items = append(items, &feeds.Item{
-			Title:       p.Title,
-			Link:        &feeds.Link{Href: path.Join(s.BaseURL(), p.Slug)},
-			Description: p.BodyHTML,
-			Created:     *p.PubTime,
-		})
+	s.render(w, "tags.html", data)
+}
+
+func (s *Server) handleTag(w http.ResponseWriter, r *http.Request) {
+	posts := s.app.GetPostsByTag(mux.Vars(r)["name"])
+	if len(posts) == 0 {
+		http.Error(w, "not found", 404)
+		return
 	}
 
-	feed.Items = items
-	rss, err := feed.ToRss()
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+	data := struct {
+		*commonData
+		Tag   *tagData
+		Posts []*articleData
+	}{
+		s.newCommonData(r),
+		newTagData(mux.Vars(r)["name"], len(posts)),
+		s.newArticleDataSlice(posts),
+	}
+
+	s.render(w, "tag.html", data)
+}
+
+// handleTagFeed serves the feed of the posts with the given tag in the
+// format given by the route.
+func (s *Server) handleTagFeed(w http.ResponseWriter, r *http.Request) {
+	posts := s.app.GetPostsByTag(mux.Vars(r)["name"])
+	if len(posts) == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if n := int(s.app.Config().FeedItems); len(posts) > n {
+		posts = posts[:n]
 	}
 
-	fmt.Fprintf(w, rss)
+	tag := newTagData(mux.Vars(r)["name"], len(posts))
+	title := fmt.Sprintf("%s: %s", s.app.Config().Title, tag.Name)
+	feed := s.newFeed(title, s.absURL(tag.URL), posts)
+	s.writeFeed(w, feed, path.Base(r.URL.Path))
 }
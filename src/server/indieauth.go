@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Micropub clients get their tokens through IndieAuth
+// (https://indieauth.spec.indieweb.org/). The authorization and token
+// endpoints are delegated to an external provider, e.g.
+// https://tokens.indieauth.com/: they're advertised in every page's <head>,
+// and the tokens presented to the Micropub endpoints are verified with the
+// token endpoint.
+
+const (
+	// Verified tokens are cached, so that the token endpoint isn't queried
+	// for every request.
+	tokenCacheExpiration = 10 * time.Minute
+
+	maxTokenResponseSize = 1 << 16
+)
+
+// tokenClient queries the token endpoint, which is configured by the site
+// owner and may be on the local network.
+var tokenClient = &http.Client{Timeout: 10 * time.Second}
+
+// tokenInfo is the response of the token endpoint to a verification request.
+type tokenInfo struct {
+	Me       string `json:"me"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+}
+
+// indieAuthEnabled reports whether tokens can be obtained through IndieAuth.
+func (s *Server) indieAuthEnabled() bool {
+	return s.app.Config().TokenEndpoint != ""
+}
+
+// validIndieAuthToken reports whether the token endpoint confirms that the
+// token was issued for the site, with a scope allowing to post.
+func (s *Server) validIndieAuthToken(token string) bool {
+	endpoint := s.app.Config().TokenEndpoint
+	if endpoint == "" {
+		return false
+	}
+	key := endpoint + " " + token
+	if s.tokens != nil {
+		if _, ok := s.tokens.Get(key); ok {
+			return true
+		}
+	}
+
+	info, err := verifyToken(endpoint, token)
+	if err != nil {
+		log.Printf("couldn't verify IndieAuth token: %v\n", err)
+		return false
+	}
+	if info == nil || !sameSite(info.Me, s.BaseURL()) || !hasPostScope(info.Scope) {
+		return false
+	}
+	if s.tokens != nil {
+		s.tokens.Set(key, info, cache.DefaultExpiration)
+	}
+	return true
+}
+
+// verifyToken asks the token endpoint about the token. It returns nil if the
+// endpoint rejects the token.
+func verifyToken(endpoint, token string) (*tokenInfo, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := tokenClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+
+	var info tokenInfo
+	body := io.LimitReader(resp.Body, maxTokenResponseSize)
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		// Older providers answer with a form-encoded body.
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		v, err := url.ParseQuery(string(b))
+		if err != nil {
+			return nil, err
+		}
+		info = tokenInfo{Me: v.Get("me"), ClientID: v.Get("client_id"), Scope: v.Get("scope")}
+	} else if err := json.NewDecoder(body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	return &info, nil
+}
+
+// sameSite reports whether the profile URL returned by the token endpoint is
+// the site's base URL.
+func sameSite(me, base string) bool {
+	u, err := url.Parse(me)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == b.Scheme && strings.EqualFold(u.Host, b.Host) &&
+		strings.TrimSuffix(u.Path, "/") == strings.TrimSuffix(b.Path, "/")
+}
+
+// hasPostScope reports whether the space-separated scopes allow to post.
+// "post" is the scope used by older clients.
+func hasPostScope(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == "create" || s == "post" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/patrickmn/go-cache"
+)
+
+func TestMicropubIndieAuth(t *testing.T) {
+	s := newTestServer(t, nil)
+	if err := s.initTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	var mux sync.Mutex
+	var queries int
+	tokens := map[string]tokenInfo{
+		"valid":      {Me: s.BaseURL() + "/", Scope: "create update"},
+		"other-site": {Me: "https://other.example/", Scope: "create"},
+		"read-only":  {Me: s.BaseURL() + "/", Scope: "read"},
+	}
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		queries++
+		mux.Unlock()
+		info, ok := tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if !ok {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(info)
+	}))
+	defer endpoint.Close()
+	s.app.Config().AuthorizationEndpoint = "https://indieauth.example/auth"
+	s.app.Config().TokenEndpoint = endpoint.URL
+	s.tokens = cache.New(tokenCacheExpiration, cacheCleanup)
+	h := s.newRouter()
+
+	// The endpoints are advertised to the clients.
+	w := get(h.ServeHTTP, "/")
+	for _, want := range []string{
+		`<link rel="micropub" href="` + s.BaseURL() + `/micropub" />`,
+		`<link rel="authorization_endpoint" href="https://indieauth.example/auth" />`,
+		`<link rel="token_endpoint" href="` + endpoint.URL + `" />`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	post := func(token string) int {
+		form := url.Values{"h": {"entry"}, "content": {"Hello " + token}}
+		req := httptest.NewRequest("POST", "/micropub", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"valid", http.StatusCreated},
+		{"unknown", http.StatusForbidden},
+		{"other-site", http.StatusForbidden},
+		{"read-only", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := post(tt.token); got != tt.want {
+			t.Errorf("%s: want status %d, got %d", tt.token, tt.want, got)
+		}
+	}
+
+	// Verified tokens are cached.
+	if got := post("valid"); got != http.StatusCreated {
+		t.Errorf("want status 201 for the cached token, got %d", got)
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	if queries != len(tests) {
+		t.Errorf("want %d queries to the token endpoint, got %d", len(tests), queries)
+	}
+}
+
+func TestSameSite(t *testing.T) {
+	tests := []struct {
+		me   string
+		want bool
+	}{
+		{"https://example.org/", true},
+		{"https://EXAMPLE.org", true},
+		{"http://example.org/", false},
+		{"https://example.org/other/", false},
+		{"https://example.org.evil/", false},
+	}
+	for _, tt := range tests {
+		if got := sameSite(tt.me, "https://example.org"); got != tt.want {
+			t.Errorf("%s: want %v, got %v", tt.me, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,734 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"presence/atomicfile"
+	"presence/model"
+	"presence/store"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Micropub server, as specified in https://www.w3.org/TR/micropub/. Entries
+// are stored as Markdown files in the posts dir: properties with an
+// equivalent front matter field are mapped onto it, the content and any
+// photos, videos and audio become the body, and the remaining properties are
+// kept in the front matter as they are.
+
+const (
+	// maxMediaSize is the request body size limit for the Micropub endpoints,
+	// which accept file uploads.
+	maxMediaSize = 16 << 20
+
+	// maxMultipartMemory is the part of a multipart body kept in memory. The
+	// rest is stored in temporary files.
+	maxMultipartMemory = 8 << 20
+
+	// maxSlugWords is the number of words used for slugs generated from the
+	// entry's name or content.
+	maxSlugWords = 6
+)
+
+var (
+	errMediaDisabled    = errors.New("media uploads are disabled")
+	errUnsupportedMedia = errors.New("unsupported media type")
+)
+
+// mediaTypes maps the accepted upload content types, as detected from the
+// file contents, to file extensions.
+var mediaTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"audio/mpeg":      ".mp3",
+	"audio/wave":      ".wav",
+	"application/ogg": ".ogg",
+}
+
+// mediaProperties are the properties whose values are embedded in the body.
+var mediaProperties = []string{"photo", "video", "audio"}
+
+// frontMatterOrder is the order of the known fields in generated front
+// matter. Other fields follow in alphabetical order.
+var frontMatterOrder = []string{
+	"title", "description", "author", "date", "updated", "tags", "aliases",
+	"template", "draft",
+}
+
+// micropubProperties holds the properties of an h-entry. Values are strings,
+// or objects for HTML content and photos with alt text.
+type micropubProperties map[string][]interface{}
+
+type micropubRequest struct {
+	Type       string
+	Properties micropubProperties
+	Action     string
+	URL        string
+	Replace    micropubProperties
+	Add        micropubProperties
+	Delete     micropubProperties // a nil slice deletes the whole property
+}
+
+func writeMicropubError(w http.ResponseWriter, code int, err, desc string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             err,
+		"error_description": desc,
+	})
+}
+
+// micropubEnabled reports whether clients can authenticate to the Micropub
+// endpoints.
+func (s *Server) micropubEnabled() bool {
+	return len(s.app.Config().APITokens) > 0 || s.indieAuthEnabled()
+}
+
+// parseMicropubForm parses the form-encoded or multipart request body. JSON
+// bodies are left unread.
+func parseMicropubForm(r *http.Request) error {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt == "multipart/form-data" {
+		return r.ParseMultipartForm(maxMultipartMemory)
+	}
+	return r.ParseForm()
+}
+
+// withMicropubAuth only lets through requests with a valid API token or
+// IndieAuth token, passed either in the Authorization header or in the
+// access_token form field. Micropub is disabled if neither API tokens nor a
+// token endpoint are configured.
+func (s *Server) withMicropubAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.micropubEnabled() {
+			http.Error(w, "not found", 404)
+			return
+		}
+		if err := parseMicropubForm(r); err != nil {
+			writeMicropubError(w, http.StatusBadRequest, "invalid_request", "couldn't parse request body")
+			return
+		}
+
+		token := r.PostForm.Get("access_token")
+		if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+			token = strings.TrimPrefix(h, "Bearer ")
+		}
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="presence"`)
+			writeMicropubError(w, http.StatusUnauthorized, "unauthorized", "missing access token")
+			return
+		}
+		if !s.validToken(token) && !s.validIndieAuthToken(token) {
+			writeMicropubError(w, http.StatusForbidden, "forbidden", "invalid access token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleMicropubQuery answers the q=config, q=syndicate-to and q=source
+// queries.
+func (s *Server) handleMicropubQuery(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("q") {
+	case "config":
+		data := struct {
+			MediaEndpoint string   `json:"media-endpoint,omitempty"`
+			SyndicateTo   []string `json:"syndicate-to"`
+			Q             []string `json:"q"`
+		}{
+			SyndicateTo: []string{},
+			Q:           []string{"config", "source", "syndicate-to"},
+		}
+		if s.app.Config().MediaDir != "" {
+			data.MediaEndpoint = s.absURL("/micropub/media")
+		}
+		writeJSON(w, r, data)
+	case "syndicate-to":
+		writeJSON(w, r, map[string][]string{"syndicate-to": {}})
+	case "source":
+		s.handleMicropubSource(w, r)
+	default:
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "unsupported query")
+	}
+}
+
+// handleMicropubSource returns the properties of a post, optionally limited to
+// the ones listed in the properties[] parameter.
+func (s *Server) handleMicropubSource(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	post := s.app.LookupPost(slugFromURL(query.Get("url")))
+	if post == nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "post not found")
+		return
+	}
+	props := articleProperties(post)
+
+	names := append(query["properties[]"], query["properties"]...)
+	if len(names) == 0 {
+		writeJSON(w, r, map[string]interface{}{
+			"type":       []string{"h-entry"},
+			"properties": props,
+		})
+		return
+	}
+	selected := make(micropubProperties)
+	for _, name := range names {
+		if values, ok := props[name]; ok {
+			selected[name] = values
+		}
+	}
+	writeJSON(w, r, map[string]interface{}{"properties": selected})
+}
+
+// handleMicropub creates, updates and deletes posts.
+func (s *Server) handleMicropub(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeMicropubRequest(r)
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+
+	switch req.Action {
+	case "", "create":
+		s.micropubCreate(w, r, req)
+	case "update":
+		s.micropubUpdate(w, r, req)
+	case "delete":
+		s.micropubDelete(w, r, req)
+	case "undelete":
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "undelete is not supported")
+	default:
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "unknown action")
+	}
+}
+
+func decodeMicropubRequest(r *http.Request) (*micropubRequest, error) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt == "application/json" {
+		return decodeMicropubJSON(r)
+	}
+
+	req := &micropubRequest{Properties: make(micropubProperties)}
+	for key, values := range r.PostForm {
+		switch key = strings.TrimSuffix(key, "[]"); key {
+		case "h":
+			req.Type = "h-" + values[0]
+		case "action":
+			req.Action = values[0]
+		case "url":
+			req.URL = values[0]
+		case "access_token":
+		default:
+			for _, v := range values {
+				req.Properties[key] = append(req.Properties[key], v)
+			}
+		}
+	}
+	return req, nil
+}
+
+func decodeMicropubJSON(r *http.Request) (*micropubRequest, error) {
+	var body struct {
+		Type       []string           `json:"type"`
+		Properties micropubProperties `json:"properties"`
+		Action     string             `json:"action"`
+		URL        string             `json:"url"`
+		Replace    micropubProperties `json:"replace"`
+		Add        micropubProperties `json:"add"`
+		Delete     json.RawMessage    `json:"delete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, errors.New("invalid JSON body")
+	}
+
+	req := &micropubRequest{
+		Properties: body.Properties,
+		Action:     body.Action,
+		URL:        body.URL,
+		Replace:    body.Replace,
+		Add:        body.Add,
+	}
+	if req.Properties == nil {
+		req.Properties = make(micropubProperties)
+	}
+	if len(body.Type) > 0 {
+		req.Type = body.Type[0]
+	}
+
+	// Deletions are either a list of property names, or values to remove
+	// from the properties.
+	if len(body.Delete) > 0 {
+		var names []string
+		if err := json.Unmarshal(body.Delete, &names); err == nil {
+			req.Delete = make(micropubProperties, len(names))
+			for _, name := range names {
+				req.Delete[name] = nil
+			}
+		} else if err := json.Unmarshal(body.Delete, &req.Delete); err != nil {
+			return nil, errors.New("invalid delete value")
+		}
+	}
+
+	return req, nil
+}
+
+func (s *Server) micropubCreate(w http.ResponseWriter, r *http.Request, req *micropubRequest) {
+	if req.Type != "h-entry" {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "only h-entry is supported")
+		return
+	}
+	props := req.Properties
+
+	if err := s.saveUploads(r, props); err != nil {
+		writeMediaError(w, err)
+		return
+	}
+	slug, err := s.micropubSlug(props)
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	source, err := entrySource(props)
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	post, err := s.app.CreatePost(slug, source)
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	log.Printf("created post via Micropub: '%s'\n", post.Slug)
+
+	w.Header().Set("Location", s.absURL("/"+post.Slug))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) micropubUpdate(w http.ResponseWriter, r *http.Request, req *micropubRequest) {
+	slug := slugFromURL(req.URL)
+	post := s.app.LookupPost(slug)
+	if post == nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "post not found")
+		return
+	}
+
+	props := articleProperties(post)
+	for key, values := range req.Replace {
+		props[key] = values
+	}
+	for key, values := range req.Add {
+		props[key] = append(props[key], values...)
+	}
+	for key, values := range req.Delete {
+		if values == nil {
+			delete(props, key)
+			continue
+		}
+		props[key] = removeValues(props[key], values)
+		if len(props[key]) == 0 {
+			delete(props, key)
+		}
+	}
+	if req.Replace["updated"] == nil && req.Add["updated"] == nil {
+		props["updated"] = []interface{}{time.Now().Format(time.RFC3339)}
+	}
+
+	source, err := entrySource(props)
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if _, err := s.app.UpdatePost(slug, source); err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	log.Printf("updated post via Micropub: '%s'\n", slug)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) micropubDelete(w http.ResponseWriter, r *http.Request, req *micropubRequest) {
+	slug := slugFromURL(req.URL)
+	if err := s.app.DeletePost(slug); err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	log.Printf("deleted post via Micropub: '%s'\n", slug)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// slugFromURL returns the slug of the post at the URL, or an empty string if
+// the URL isn't a post URL.
+func slugFromURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	slug := strings.Trim(u.Path, "/")
+	if !store.IsValidSlug(slug) {
+		return ""
+	}
+	return slug
+}
+
+// micropubSlug returns the slug requested by the client with mp-slug, or
+// generates an unused one from the name or the content of the entry.
+func (s *Server) micropubSlug(props micropubProperties) (string, error) {
+	if slug := firstString(props["mp-slug"]); slug != "" {
+		if !store.IsValidSlug(slug) {
+			return "", store.ErrInvalidSlug
+		}
+		return slug, nil
+	}
+
+	base := slugify(firstString(props["name"]))
+	if base == "" {
+		base = slugify(stripTags(strings.Join(contentValues(props["content"]), " ")))
+	}
+	if base == "" {
+		base = time.Now().Format("20060102-150405")
+	}
+	slug := base
+	for i := 2; s.app.LookupPost(slug) != nil; i++ {
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+	return slug, nil
+}
+
+// slugify joins the first few words of the text into a slug. Characters
+// other than ASCII letters and digits are treated as word separators.
+func slugify(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > maxSlugWords {
+		words = words[:maxSlugWords]
+	}
+	return strings.Join(words, "-")
+}
+
+// firstString returns the first value as a string. For objects, the "value"
+// field is used.
+func firstString(values []interface{}) string {
+	if len(values) == 0 {
+		return ""
+	}
+	switch v := values[0].(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		s, _ := v["value"].(string)
+		return s
+	}
+	return ""
+}
+
+// contentValues returns the content values as Markdown. HTML content is
+// included as it is.
+func contentValues(values []interface{}) []string {
+	var result []string
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			result = append(result, v)
+		case map[string]interface{}:
+			if s, ok := v["html"].(string); ok {
+				result = append(result, s)
+			} else if s, ok := v["value"].(string); ok {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+// mediaMarkdown returns the Markdown embedding the photo, video or audio.
+func mediaMarkdown(kind string, v interface{}) string {
+	var src, alt string
+	switch v := v.(type) {
+	case string:
+		src = v
+	case map[string]interface{}:
+		src, _ = v["value"].(string)
+		alt, _ = v["alt"].(string)
+	}
+	if src == "" {
+		return ""
+	}
+	switch kind {
+	case "video":
+		return fmt.Sprintf(`<video controls src="%s"></video>`, html.EscapeString(src))
+	case "audio":
+		return fmt.Sprintf(`<audio controls src="%s"></audio>`, html.EscapeString(src))
+	}
+	alt = strings.NewReplacer("[", `\[`, "]", `\]`).Replace(alt)
+	return fmt.Sprintf("![%s](<%s>)", alt, src)
+}
+
+// entrySource returns the contents of the Markdown file for the entry.
+func entrySource(props micropubProperties) ([]byte, error) {
+	fm := make(map[string]interface{})
+	var body []string
+
+	for key, values := range props {
+		if len(values) == 0 || strings.HasPrefix(key, "mp-") {
+			continue
+		}
+		switch key {
+		case "content":
+			body = append(body, contentValues(values)...)
+		case "name":
+			fm["title"] = firstString(values)
+		case "summary":
+			fm["description"] = firstString(values)
+		case "published":
+			fm["date"] = firstString(values)
+		case "updated":
+			fm["updated"] = firstString(values)
+		case "category":
+			var tags []string
+			for _, v := range values {
+				if s, ok := v.(string); ok {
+					tags = append(tags, s)
+				}
+			}
+			fm["tags"] = tags
+		case "post-status":
+			if firstString(values) == "draft" {
+				fm["draft"] = true
+			}
+		case "photo", "video", "audio":
+			// Appended to the body below, in a fixed order.
+		default:
+			if len(values) == 1 {
+				fm[key] = values[0]
+			} else {
+				fm[key] = values
+			}
+		}
+	}
+	for _, kind := range mediaProperties {
+		for _, v := range props[kind] {
+			if md := mediaMarkdown(kind, v); md != "" {
+				body = append(body, md)
+			}
+		}
+	}
+
+	var ordered yaml.MapSlice
+	for _, key := range frontMatterOrder {
+		if v, ok := fm[key]; ok {
+			ordered = append(ordered, yaml.MapItem{Key: key, Value: v})
+			delete(fm, key)
+		}
+	}
+	var rest []string
+	for key := range fm {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		ordered = append(ordered, yaml.MapItem{Key: key, Value: fm[key]})
+	}
+
+	block, err := yaml.Marshal(ordered)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	if len(ordered) > 0 {
+		b.Write(block)
+	}
+	b.WriteString("---\n\n")
+	b.WriteString(strings.Join(body, "\n\n"))
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
+
+// articleProperties returns the Micropub properties of the article. It's the
+// inverse of entrySource, except that photos, videos and audio are part of
+// the content.
+func articleProperties(a *model.Article) micropubProperties {
+	props := make(micropubProperties)
+	add := func(key string, v interface{}) {
+		props[key] = append(props[key], v)
+	}
+
+	if a.Title != "" {
+		add("name", a.Title)
+	}
+	if a.Description != "" {
+		add("summary", a.Description)
+	}
+	if a.Author != "" {
+		add("author", a.Author)
+	}
+	if content := strings.TrimSpace(string(a.BodyMarkdown)); content != "" {
+		add("content", content)
+	}
+	if a.PubTime != nil {
+		add("published", a.PubTime.Format(time.RFC3339))
+	}
+	if a.Updated != nil {
+		add("updated", a.Updated.Format(time.RFC3339))
+	}
+	for _, tag := range a.Tags {
+		add("category", tag)
+	}
+	if a.Draft {
+		add("post-status", "draft")
+	} else {
+		add("post-status", "published")
+	}
+	for _, alias := range a.Aliases {
+		add("aliases", alias)
+	}
+	if a.Template != "" {
+		add("template", a.Template)
+	}
+	for key, v := range a.Params {
+		if list, ok := v.([]interface{}); ok {
+			// Copied, so that updates don't modify the stored article.
+			props[key] = append([]interface{}(nil), list...)
+		} else {
+			add(key, v)
+		}
+	}
+
+	return props
+}
+
+// removeValues returns the values not present in remove.
+func removeValues(values, remove []interface{}) []interface{} {
+	var result []interface{}
+	for _, v := range values {
+		found := false
+		for _, r := range remove {
+			if reflect.DeepEqual(v, r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// saveUploads stores the files uploaded along with the entry in a multipart
+// request, and adds their URLs to the entry's properties.
+func (s *Server) saveUploads(r *http.Request, props micropubProperties) error {
+	if r.MultipartForm == nil {
+		return nil
+	}
+	for _, kind := range mediaProperties {
+		files := r.MultipartForm.File[kind]
+		files = append(files, r.MultipartForm.File[kind+"[]"]...)
+		for _, fh := range files {
+			u, err := s.saveMedia(fh)
+			if err != nil {
+				return err
+			}
+			props[kind] = append(props[kind], u)
+		}
+	}
+	return nil
+}
+
+// saveMedia stores the uploaded file in the media dir and returns its URL.
+// Files are named after a hash of their contents, so uploading the same file
+// twice results in the same URL.
+func (s *Server) saveMedia(fh *multipart.FileHeader) (string, error) {
+	dir := s.app.Config().MediaDir
+	if dir == "" {
+		return "", errMediaDisabled
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	ext, ok := mediaTypes[http.DetectContentType(data)]
+	if !ok {
+		return "", errUnsupportedMedia
+	}
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:16]) + ext
+	fp := filepath.Join(dir, name)
+
+	if _, err := os.Stat(fp); os.IsNotExist(err) {
+		// Written to a temporary file first, so that partial uploads are never
+		// served.
+		if err := atomicfile.Write(fp, data, 0644); err != nil {
+			return "", err
+		}
+		log.Printf("saved media file: '%s'\n", name)
+	} else if err != nil {
+		return "", err
+	}
+
+	return s.absURL("/media/" + name), nil
+}
+
+func writeMediaError(w http.ResponseWriter, err error) {
+	switch err {
+	case errMediaDisabled:
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+	case errUnsupportedMedia:
+		writeMicropubError(w, http.StatusUnsupportedMediaType, "invalid_request", err.Error())
+	default:
+		log.Println(err)
+		writeMicropubError(w, http.StatusInternalServerError, "server_error", "couldn't save file")
+	}
+}
+
+// handleMicropubMedia is the Micropub media endpoint. It stores the file
+// uploaded in the "file" field and responds with its URL.
+func (s *Server) handleMicropubMedia(w http.ResponseWriter, r *http.Request) {
+	if r.MultipartForm == nil || len(r.MultipartForm.File["file"]) == 0 {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", "missing file")
+		return
+	}
+	u, err := s.saveMedia(r.MultipartForm.File["file"][0])
+	if err != nil {
+		writeMediaError(w, err)
+		return
+	}
+	w.Header().Set("Location", u)
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newMicropubTestServer(t *testing.T) (*Server, http.Handler) {
+	s := newTestServer(t, nil)
+	s.app.Config().APITokens = []string{"secret"}
+	s.app.Config().MediaDir = filepath.Join(filepath.Dir(s.app.Config().PostsDir), "media")
+	return s, s.newRouter()
+}
+
+func doMicropub(h http.Handler, method, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestMicropubAuth(t *testing.T) {
+	_, h := newMicropubTestServer(t)
+
+	form := url.Values{"h": {"entry"}, "content": {"Hello"}}
+	req := httptest.NewRequest("POST", "/micropub", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("want status 401 without token, got %d", w.Code)
+	}
+
+	form.Set("access_token", "wrong")
+	req = httptest.NewRequest("POST", "/micropub", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("want status 403 for invalid token, got %d", w.Code)
+	}
+
+	form.Set("access_token", "secret")
+	req = httptest.NewRequest("POST", "/micropub", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Errorf("want status 201 with token in body, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestMicropubCreateForm(t *testing.T) {
+	s, h := newMicropubTestServer(t)
+
+	form := url.Values{
+		"h":          {"entry"},
+		"name":       {"Hello, world!"},
+		"content":    {"First *post*."},
+		"category[]": {"misc", "notes"},
+	}
+	w := doMicropub(h, "POST", "/micropub", "application/x-www-form-urlencoded", form.Encode())
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status 201, got %d: %s", w.Code, w.Body)
+	}
+	if loc := w.Header().Get("Location"); loc != "http://example.org/hello-world" {
+		t.Errorf("unexpected Location: %s", loc)
+	}
+
+	post := s.app.GetPost("hello-world")
+	if post == nil {
+		t.Fatal("post not created")
+	}
+	if post.Title != "Hello, world!" || strings.Join(post.Tags, ",") != "misc,notes" {
+		t.Errorf("unexpected post: %+v", post)
+	}
+	if !strings.Contains(post.BodyHTML, "<em>post</em>") {
+		t.Errorf("unexpected body: %s", post.BodyHTML)
+	}
+
+	// Notes without a name get a slug from the content, made unique if
+	// needed.
+	form = url.Values{"h": {"entry"}, "content": {"Hello world"}}
+	w = doMicropub(h, "POST", "/micropub", "application/x-www-form-urlencoded", form.Encode())
+	if loc := w.Header().Get("Location"); loc != "http://example.org/hello-world-2" {
+		t.Errorf("unexpected Location: %s", loc)
+	}
+}
+
+func TestMicropubJSON(t *testing.T) {
+	s, h := newMicropubTestServer(t)
+
+	create := `{
+		"type": ["h-entry"],
+		"properties": {
+			"content": [{"html": "<p>Some <b>HTML</b></p>"}],
+			"photo": [{"value": "https://example.org/a.jpg", "alt": "A photo"}],
+			"post-status": ["draft"],
+			"mp-slug": ["note"]
+		}
+	}`
+	w := doMicropub(h, "POST", "/micropub", "application/json", create)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status 201, got %d: %s", w.Code, w.Body)
+	}
+	post := s.app.LookupPost("note")
+	if post == nil || !post.Draft {
+		t.Fatalf("draft not created: %+v", post)
+	}
+	if !strings.Contains(post.BodyHTML, `<img src="https://example.org/a.jpg" alt="A photo">`) {
+		t.Errorf("photo not embedded: %s", post.BodyHTML)
+	}
+
+	update := `{
+		"action": "update",
+		"url": "http://example.org/note",
+		"replace": {"post-status": ["published"]},
+		"add": {"category": ["photos"], "name": ["A note"]}
+	}`
+	w = doMicropub(h, "POST", "/micropub", "application/json", update)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("want status 204, got %d: %s", w.Code, w.Body)
+	}
+	post = s.app.GetPost("note")
+	if post == nil || post.Title != "A note" || post.Updated == nil {
+		t.Fatalf("post not updated: %+v", post)
+	}
+
+	w = doMicropub(h, "GET", "/micropub?q=source&properties[]=name&properties[]=category&url=http://example.org/note", "", "")
+	var source struct {
+		Properties map[string][]string `json:"properties"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &source); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"name": {"A note"}, "category": {"photos"}}
+	if len(source.Properties) != 2 || source.Properties["name"][0] != "A note" ||
+		source.Properties["category"][0] != "photos" {
+		t.Errorf("want properties %v, got %v", want, source.Properties)
+	}
+
+	remove := `{"action": "update", "url": "http://example.org/note", "delete": ["category"]}`
+	doMicropub(h, "POST", "/micropub", "application/json", remove)
+	if post = s.app.GetPost("note"); len(post.Tags) != 0 {
+		t.Errorf("category not deleted: %v", post.Tags)
+	}
+
+	w = doMicropub(h, "POST", "/micropub", "application/json",
+		`{"action": "delete", "url": "http://example.org/note"}`)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("want status 204, got %d: %s", w.Code, w.Body)
+	}
+	if s.app.LookupPost("note") != nil {
+		t.Error("post not deleted")
+	}
+}
+
+func TestMicropubMedia(t *testing.T) {
+	s, h := newMicropubTestServer(t)
+
+	w := doMicropub(h, "GET", "/micropub?q=config", "", "")
+	if !strings.Contains(w.Body.String(), `"media-endpoint": "http://example.org/micropub/media"`) {
+		t.Errorf("media endpoint not advertised: %s", w.Body)
+	}
+
+	upload := func(data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, err := mw.CreateFormFile("file", "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+		mw.Close()
+		return doMicropub(h, "POST", "/micropub/media", mw.FormDataContentType(), body.String())
+	}
+
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	w = upload(png)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status 201, got %d: %s", w.Code, w.Body)
+	}
+	loc := w.Header().Get("Location")
+	if !strings.HasPrefix(loc, "http://example.org/media/") || !strings.HasSuffix(loc, ".png") {
+		t.Errorf("unexpected Location: %s", loc)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.app.Config().MediaDir, filepath.Base(loc)))
+	if err != nil || !bytes.Equal(data, png) {
+		t.Errorf("file not saved: %v", err)
+	}
+	if got := get(h.ServeHTTP, strings.TrimPrefix(loc, "http://example.org")); got.Code != 200 {
+		t.Errorf("want status 200 for uploaded file, got %d", got.Code)
+	}
+
+	if w := upload([]byte("<html><script></script></html>")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("want status 415 for HTML upload, got %d", w.Code)
+	}
+}
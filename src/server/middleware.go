@@ -2,14 +2,26 @@ package server
 
 import (
 	"net/http"
+	"strings"
 	"time"
 )
 
+// maxBodySize returns the request body size limit for the request.
+func maxBodySize(r *http.Request) int64 {
+	if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/inbox" {
+		return maxAPIBodySize
+	}
+	if r.URL.Path == "/micropub" || strings.HasPrefix(r.URL.Path, "/micropub/") {
+		return maxMediaSize
+	}
+	return 10 * 1024
+}
+
 func (s *Server) withCommonHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, 10*1024)
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize(r))
 
-		if s.app.Config.ForceTLS {
+		if s.app.Config().ForceTLS {
 			w.Header().Add(
 				"Strict-Transport-Security",
 				"max-age=63072000; includeSubDomains",
@@ -31,6 +43,13 @@ func (r *statusCodeRecorder) WriteHeader(statusCode int) {
 	r.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Flush lets streaming handlers flush the response, e.g. Server-Sent Events.
+func (r *statusCodeRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (s *Server) withLogging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		timeStart := time.Now()
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"html"
+	"html/template"
+	"net/http"
+	"presence/store"
+	"strings"
+)
+
+const (
+	maxSearchResults = 50
+	snippetWords     = 30
+)
+
+type searchResultData struct {
+	*articleData
+	Snippet template.HTML
+}
+
+// snippetHTML returns an excerpt of the article text with the matching terms
+// wrapped in <mark> elements.
+func snippetHTML(text string, terms []string) template.HTML {
+	var b strings.Builder
+	for _, f := range store.Snippet(text, terms, snippetWords) {
+		if f.Match {
+			b.WriteString("<mark>" + html.EscapeString(f.Text) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(f.Text))
+		}
+	}
+	return template.HTML(b.String())
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	var results []*searchResultData
+	var total int
+	if q != "" {
+		found := s.app.SearchPosts(q)
+		total = len(found)
+		if len(found) > maxSearchResults {
+			found = found[:maxSearchResults]
+		}
+		for _, res := range found {
+			results = append(results, &searchResultData{
+				articleData: s.newArticleData(res.Article),
+				Snippet:     snippetHTML(store.BodyText(res.Article), res.Terms),
+			})
+		}
+	}
+
+	data := struct {
+		*commonData
+		Query   string
+		Results []*searchResultData
+		Total   int
+	}{
+		s.newCommonData(r),
+		q,
+		results,
+		total,
+	}
+
+	s.render(w, "search.html", data)
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearchSnippet(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"post.946728000.md": "# Post\n\nSome <b>x</b>gamma text.",
+	})
+	if err := s.initTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snippet is extracted from the indexed text, so that matches next
+	// to markup are highlighted.
+	w := get(s.handleSearch, "/search?q=gamma")
+	if body := w.Body.String(); !strings.Contains(body, "<mark>gamma</mark>") {
+		t.Errorf("match not highlighted in:\n%s", body)
+	}
+}
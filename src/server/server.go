@@ -2,32 +2,47 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"html/template"
 	"log"
 	"net"
 	"net/http"
-	"os"
-	"path"
-	"path/filepath"
 	"presence/app"
+	"presence/config"
+	"presence/gemini"
+	"presence/gopher"
 	"presence/logger"
+	"presence/theme"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/handlers"
+	"github.com/fsnotify/fsnotify"
 	"github.com/gorilla/mux"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type Server struct {
-	app       *app.App
-	srv       *http.Server
-	srvtls    *http.Server
-	templates map[string]*template.Template
-	accessLog *logger.Logger
-	done      chan struct{}
-	once      sync.Once
+	app        *app.App
+	srv        *http.Server
+	srvtls     *http.Server
+	gemini     *gemini.Server
+	gopher     *gopher.Server
+	certs      *certLoader
+	changes    changes // template and config reloads
+	cache      *pageCache
+	tokens     *cache.Cache // verified IndieAuth tokens
+	acme       *autocert.Manager
+	templates  atomic.Value // map[string]*template.Template
+	tplWatcher *fsnotify.Watcher
+	accessLog  *logger.Logger
+	dev        *devReloader // set in dev mode
+	devWatcher *fsnotify.Watcher
+	done       chan struct{}
+	once       sync.Once
+	writeMux   sync.Mutex // serializes writes through the API
 }
 
 func New(a *app.App) (*Server, error) {
@@ -36,15 +51,18 @@ func New(a *app.App) (*Server, error) {
 		accessLog: logger.NewLogger(),
 	}
 
-	if s.app.Config.AccessLog == "" {
+	if s.app.Config().AccessLog == "" {
 		s.accessLog = logger.NewLogger()
 	} else {
-		l, err := logger.NewFileLogger(s.app.Config.AccessLog, true)
+		l, err := logger.NewFileLogger(s.app.Config().AccessLog, true)
 		if err != nil {
 			return nil, err
 		}
 		s.accessLog = l
 	}
+	if err := logger.SetStandardFile(s.app.Config().ErrorLog); err != nil {
+		return nil, err
+	}
 
 	if err := s.initServers(); err != nil {
 		return nil, err
@@ -52,42 +70,84 @@ func New(a *app.App) (*Server, error) {
 	if err := s.initTemplates(); err != nil {
 		return nil, fmt.Errorf("couldn't init templates: %v", err)
 	}
+	s.initCache()
+	s.tokens = cache.New(tokenCacheExpiration, cacheCleanup)
 
 	return s, nil
 }
 
+// BaseURL returns the public URL of the site, without the trailing slash.
 func (s *Server) BaseURL() string {
-	if s.app.Config.PortTLS != 0 {
-		var port string
-		if s.app.Config.PortTLS != 443 {
-			port = fmt.Sprintf(":%d", s.app.Config.PortTLS)
-		}
-		return "https://" + s.app.Config.Host + port
-	}
-	var port string
-	if s.app.Config.Port != 80 {
-		port = fmt.Sprintf(":%d", s.app.Config.Port)
-	}
-	return "http://" + s.app.Config.Host + port
+	return s.app.Config().BaseURL()
 }
 
-func (s *Server) newHTTPServer(port uint) *http.Server {
+// absURL returns the absolute URL for the root-relative path.
+func (s *Server) absURL(path string) string {
+	return s.BaseURL() + path
+}
+
+// newRouter returns the handler for all the routes served by the site.
+func (s *Server) newRouter() *mux.Router {
 	r := mux.NewRouter()
 
-	if s.app.Config.StaticDir != "" {
-		fs := http.FileServer(FileSystem{http.Dir(s.app.Config.StaticDir)})
-		r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fs))
-	} else {
-		log.Println("warning: unset static_dir - not serving static files")
-	}
+	static := theme.Layer(s.app.Config().StaticDir, theme.Static)
+	fs := http.FileServer(FileSystem{http.FS(static)})
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fs))
 
-	r.HandleFunc("/", s.handleHome)
-	r.HandleFunc("/rss.xml", s.handleRSS)
-	r.HandleFunc("/archive", s.handleArchive)
-	r.HandleFunc("/{page:[0-9]+}/", s.handleHome)
-	r.HandleFunc("/{slug:[a-zA-Z0-9_-]+}", s.handleArticle)
+	if s.app.Config().MediaDir != "" {
+		fs := http.FileServer(FileSystem{http.Dir(s.app.Config().MediaDir)})
+		r.PathPrefix("/media/").Handler(http.StripPrefix("/media/", fs))
+		r.HandleFunc("/micropub/media", s.withMicropubAuth(s.handleMicropubMedia)).Methods("POST")
+	}
+	r.HandleFunc("/webmention", s.handleWebmention).Methods("POST")
+	r.HandleFunc("/_dev/events", s.handleDevEvents).Methods("GET")
+	r.HandleFunc("/.well-known/webfinger", s.withFederation(s.handleWebFinger)).Methods("GET", "HEAD")
+	r.HandleFunc("/actor", s.withFederation(s.handleActor)).Methods("GET", "HEAD")
+	r.HandleFunc("/outbox", s.withFederation(s.handleOutbox)).Methods("GET", "HEAD")
+	r.HandleFunc("/followers", s.withFederation(s.handleFollowers)).Methods("GET", "HEAD")
+	r.HandleFunc("/inbox", s.withFederation(s.handleInbox)).Methods("POST")
+	r.HandleFunc("/micropub", s.withMicropubAuth(s.handleMicropubQuery)).Methods("GET", "HEAD")
+	r.HandleFunc("/micropub", s.withMicropubAuth(s.handleMicropub)).Methods("POST")
+
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/posts", s.handleAPIPosts).Methods("GET", "HEAD")
+	api.HandleFunc("/posts/{slug:[a-zA-Z0-9_-]+}", s.handleAPIPost).Methods("GET", "HEAD")
+	api.HandleFunc("/pages", s.handleAPIPages).Methods("GET", "HEAD")
+	api.HandleFunc("/site", s.handleAPISite).Methods("GET", "HEAD")
+	api.HandleFunc("/posts", s.withAuth(s.handleAPICreatePost)).Methods("POST")
+	api.HandleFunc("/posts/{slug:[a-zA-Z0-9_-]+}", s.withAuth(s.handleAPIUpdatePost)).Methods("PUT")
+	api.HandleFunc("/posts/{slug:[a-zA-Z0-9_-]+}", s.withAuth(s.handleAPIDeletePost)).Methods("DELETE")
+
+	// The routes behind the page cache are named, see withCompression.
+	cached := func(path string, h http.HandlerFunc) {
+		r.HandleFunc(path, s.withValidators(s.withCache(h))).Name(cachedRoutePrefix + path)
+	}
+	cached("/", s.handleHome)
+	cached("/rss.xml", s.handleFeed)
+	cached("/atom.xml", s.handleFeed)
+	cached("/feed.json", s.handleFeed)
+	cached("/archive", s.handleArchive)
+	r.HandleFunc("/sitemap.xml", s.withValidators(s.handleSitemap))
+	r.HandleFunc("/sitemap-{n:[0-9]+}.xml", s.withValidators(s.handleSitemapPart))
+	r.HandleFunc("/robots.txt", s.withValidators(s.handleRobots))
+	r.HandleFunc("/tags", s.withValidators(s.handleTags))
+	r.HandleFunc("/search", s.withValidators(s.handleSearch))
+	r.HandleFunc("/tag/{name}", s.withValidators(s.handleTag))
+	r.HandleFunc("/tag/{name}/rss.xml", s.withValidators(s.handleTagFeed))
+	r.HandleFunc("/tag/{name}/atom.xml", s.withValidators(s.handleTagFeed))
+	r.HandleFunc("/tag/{name}/feed.json", s.withValidators(s.handleTagFeed))
+	r.HandleFunc("/preview/{slug:[a-zA-Z0-9_-]+}", s.handlePreview)
+	cached("/{page:[0-9]+}/", s.handleHome)
+	r.HandleFunc("/{slug:[a-zA-Z0-9_-]+}.md", s.withValidators(s.handleArticleMarkdown))
+	r.HandleFunc("/{slug:[a-zA-Z0-9_-]+}.txt", s.withValidators(s.handleArticleText))
+	cached("/{slug:[a-zA-Z0-9_-]+}", s.handleArticle)
+
+	return r
+}
 
-	h := s.withLogging(handlers.CompressHandler(s.withCommonHeaders(r)))
+func (s *Server) newHTTPServer(port uint) *http.Server {
+	r := s.newRouter()
+	h := s.withLogging(withCompression(r, s.withCommonHeaders(r)))
 
 	return &http.Server{
 		Addr:         fmt.Sprintf(":%d", port),
@@ -99,10 +159,10 @@ func (s *Server) newHTTPServer(port uint) *http.Server {
 
 func (s *Server) newTLSRedirectServer() *http.Server {
 	redirect := func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, path.Join(s.BaseURL(), r.URL.Path), 301)
+		http.Redirect(w, r, s.absURL(r.URL.Path), 301)
 	}
 	return &http.Server{
-		Addr:         fmt.Sprintf(":%d", s.app.Config.Port),
+		Addr:         fmt.Sprintf(":%d", s.app.Config().Port),
 		WriteTimeout: 5 * time.Second,
 		ReadTimeout:  5 * time.Second,
 		Handler:      http.HandlerFunc(redirect),
@@ -111,62 +171,54 @@ func (s *Server) newTLSRedirectServer() *http.Server {
 
 // initServers initializes the http.Servers based on app config.
 func (s *Server) initServers() error {
-	if s.app.Config.Port == 0 {
+	if s.app.Config().Port == 0 {
 		return fmt.Errorf("HTTP port is not set; check your configuration")
 	}
-	if s.app.Config.PortTLS != 0 {
-		if s.app.Config.TLSKey == "" || s.app.Config.TLSCert == "" {
-			return fmt.Errorf("TLS key and certificate must be set to handle HTTPS requests")
+	if s.app.Config().ACME && s.app.Config().PortTLS == 0 {
+		return fmt.Errorf("port_tls must be set to use ACME")
+	}
+	var tlsConfig *tls.Config
+	if s.app.Config().PortTLS != 0 || s.app.Config().GeminiPort != 0 {
+		switch {
+		case s.app.Config().ACME:
+			m, err := s.newACMEManager()
+			if err != nil {
+				return err
+			}
+			s.acme = m
+			tlsConfig = m.TLSConfig()
+		case s.app.Config().TLSKey != "" && s.app.Config().TLSCert != "":
+			s.certs = &certLoader{}
+			tlsConfig = &tls.Config{GetCertificate: s.certs.GetCertificate}
+		default:
+			return fmt.Errorf("TLS key and certificate, or ACME, must be set to handle HTTPS and Gemini requests")
 		}
-		s.srvtls = s.newHTTPServer(s.app.Config.PortTLS)
-		if s.app.Config.ForceTLS {
+	}
+	if s.app.Config().PortTLS != 0 {
+		s.srvtls = s.newHTTPServer(s.app.Config().PortTLS)
+		s.srvtls.TLSConfig = tlsConfig
+		if s.app.Config().ForceTLS {
 			s.srv = s.newTLSRedirectServer()
 		}
 	}
 	if s.srv == nil {
-		s.srv = s.newHTTPServer(s.app.Config.Port)
+		s.srv = s.newHTTPServer(s.app.Config().Port)
 	}
-	return nil
-}
-
-func (s *Server) initTemplates() error {
-	dir := s.app.Config.TemplatesDir
-	if dir == "" {
-		return fmt.Errorf("templates_dir must be set")
+	if s.acme != nil {
+		// Answer HTTP-01 challenges on the plain HTTP server.
+		s.srv.Handler = s.acme.HTTPHandler(s.srv.Handler)
 	}
-	// Create the dir and its parents if they don't exist.
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+	if s.app.Config().GeminiPort != 0 {
+		s.gemini = s.newGeminiServer(tlsConfig)
 	}
-
-	templates := make(map[string]*template.Template)
-	fps := []string{
-		filepath.Join(dir, "home.html"),
-		filepath.Join(dir, "article.html"),
-		filepath.Join(dir, "archive.html"),
+	if s.app.Config().GopherPort != 0 {
+		s.gopher = s.newGopherServer()
 	}
-	shared := []string{
-		filepath.Join(dir, "meta.html"),
-		filepath.Join(dir, "header.html"),
-		filepath.Join(dir, "footer.html"),
-	}
-
-	// Group templates with their dependencies.
-	for _, fp := range fps {
-		group := append([]string{fp}, shared...)
-		t, err := template.ParseFiles(group...)
-		if err != nil {
-			return err
-		}
-		templates[t.Name()] = t
-	}
-
-	s.templates = templates
 	return nil
 }
 
 func (s *Server) getRemoteAddressForRequest(r *http.Request) string {
-	proxies := int(s.app.Config.ProxyCount)
+	proxies := int(s.app.Config().ProxyCount)
 	if proxies > 0 {
 		h := r.Header.Get("X-Forwarded-For")
 		if h != "" {
@@ -188,12 +240,21 @@ func (s *Server) Run() error {
 	if s.done != nil {
 		panic("Server.Run called twice")
 	}
+	if s.certs != nil {
+		if err := s.certs.load(s.app.Config().TLSCert, s.app.Config().TLSKey); err != nil {
+			return fmt.Errorf("couldn't load TLS certificate: %v", err)
+		}
+	}
 
 	s.done = make(chan struct{})
 	errch := make(chan error)
 
+	if err := s.watchTemplates(); err != nil {
+		log.Printf("warning: %v - not reloading templates on changes\n", err)
+	}
+
 	go func() {
-		log.Printf("starting HTTP server at :%d...", s.app.Config.Port)
+		log.Printf("starting HTTP server at :%d...", s.app.Config().Port)
 		err := s.srv.ListenAndServe()
 		if err != nil && err != http.ErrServerClosed {
 			log.Printf("server error: %v\n", err)
@@ -203,8 +264,8 @@ func (s *Server) Run() error {
 
 	if s.srvtls != nil {
 		go func() {
-			log.Printf("starting HTTPS server at :%d...", s.app.Config.PortTLS)
-			err := s.srv.ListenAndServeTLS(s.app.Config.TLSCert, s.app.Config.TLSKey)
+			log.Printf("starting HTTPS server at :%d...", s.app.Config().PortTLS)
+			err := s.srvtls.ListenAndServeTLS("", "")
 			if err != nil && err != http.ErrServerClosed {
 				log.Printf("server error: %v\n", err)
 				errch <- err
@@ -212,6 +273,28 @@ func (s *Server) Run() error {
 		}()
 	}
 
+	if s.gemini != nil {
+		go func() {
+			log.Printf("starting Gemini server at :%d...", s.app.Config().GeminiPort)
+			err := s.gemini.ListenAndServe()
+			if err != nil && err != gemini.ErrServerClosed {
+				log.Printf("server error: %v\n", err)
+				errch <- err
+			}
+		}()
+	}
+
+	if s.gopher != nil {
+		go func() {
+			log.Printf("starting Gopher server at :%d...", s.app.Config().GopherPort)
+			err := s.gopher.ListenAndServe()
+			if err != nil && err != gopher.ErrServerClosed {
+				log.Printf("server error: %v\n", err)
+				errch <- err
+			}
+		}()
+	}
+
 	// Assume things are running smoothly one second in with no errors.
 	check := time.AfterFunc(1*time.Second, func() {
 		log.Printf("services are ready")
@@ -235,8 +318,8 @@ func (s *Server) close() {
 
 	log.Println("waiting for connections to finish...")
 
+	wg.Add(1)
 	go func() {
-		wg.Add(1)
 		if err := s.srv.Shutdown(ctx); err != nil {
 			log.Printf("HTTP server shutdown: %v", err)
 		}
@@ -244,8 +327,8 @@ func (s *Server) close() {
 	}()
 
 	if s.srvtls != nil {
+		wg.Add(1)
 		go func() {
-			wg.Add(1)
 			if err := s.srvtls.Shutdown(ctx); err != nil {
 				log.Printf("HTTPS server shutdown: %v", err)
 			}
@@ -253,8 +336,35 @@ func (s *Server) close() {
 		}()
 	}
 
+	if s.gemini != nil {
+		wg.Add(1)
+		go func() {
+			if err := s.gemini.Shutdown(ctx); err != nil {
+				log.Printf("Gemini server shutdown: %v", err)
+			}
+			wg.Done()
+		}()
+	}
+
+	if s.gopher != nil {
+		wg.Add(1)
+		go func() {
+			if err := s.gopher.Shutdown(ctx); err != nil {
+				log.Printf("Gopher server shutdown: %v", err)
+			}
+			wg.Done()
+		}()
+	}
+
 	wg.Wait()
+	if s.tplWatcher != nil {
+		s.tplWatcher.Close()
+	}
+	if s.devWatcher != nil {
+		s.devWatcher.Close()
+	}
 	s.accessLog.Close()
+	logger.SetStandardFile("")
 	close(s.done)
 }
 
@@ -266,3 +376,32 @@ func (s *Server) Close() {
 		s.close()
 	})
 }
+
+// ReloadConfig applies the settings of the reloaded configuration which can
+// change while the server is running, and logs those requiring a restart. The
+// logs and the TLS certificate are reopened even if their paths are unchanged,
+// so that rotated files are picked up.
+func (s *Server) ReloadConfig(c *config.Config) {
+	conf, applied, restart := config.Merge(s.app.Config(), c)
+	s.app.SetConfig(conf)
+	s.changes.touch()
+	s.cache.flush()
+	if len(applied) > 0 {
+		log.Printf("applied config changes: %s\n", strings.Join(applied, ", "))
+	}
+	if len(restart) > 0 {
+		log.Printf("warning: restart required to apply config changes: %s\n", strings.Join(restart, ", "))
+	}
+
+	if err := s.accessLog.SetFile(conf.AccessLog); err != nil {
+		log.Printf("couldn't reopen access log: %v\n", err)
+	}
+	if err := logger.SetStandardFile(conf.ErrorLog); err != nil {
+		log.Printf("couldn't reopen error log: %v\n", err)
+	}
+	if s.certs != nil {
+		if err := s.certs.load(conf.TLSCert, conf.TLSKey); err != nil {
+			log.Printf("couldn't reload TLS certificate, keeping the previous one: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"presence/app"
+	"presence/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a Server backed by temporary posts and pages
+// directories. The posts map filenames to contents.
+func newTestServer(t *testing.T, posts map[string]string) *Server {
+	tmpdir, err := ioutil.TempDir("", "presence_test_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpdir) })
+
+	conf := &config.Config{
+		SiteConfig: &config.SiteConfig{
+			Title:             "Test",
+			MaxEntriesPerPage: 10,
+			DateFormat:        "%F",
+			FeedItems:         10,
+		},
+		ServerConfig: &config.ServerConfig{
+			Host:     "example.org",
+			Port:     80,
+			PostsDir: filepath.Join(tmpdir, "posts"),
+			PagesDir: filepath.Join(tmpdir, "pages"),
+		},
+	}
+	for _, dir := range []string{conf.PostsDir, conf.PagesDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for fname, text := range posts {
+		fp := filepath.Join(conf.PostsDir, fname)
+		if err := ioutil.WriteFile(fp, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := app.New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.Close)
+
+	return &Server{app: a}
+}
+
+// get performs a GET request against the handler and returns the recorded
+// response.
+func get(h http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
+
+func TestSitemapIndex(t *testing.T) {
+	posts := make(map[string]string)
+	for i := 1; i <= 5; i++ {
+		posts[fmt.Sprintf("post-%d.%d.md", i, i)] = "# Post"
+	}
+	s := newTestServer(t, posts)
+	r := s.newRouter()
+
+	defer func(n int) { maxSitemapURLs = n }(maxSitemapURLs)
+	maxSitemapURLs = 3
+
+	// 3 listings + 5 posts = 8 URLs, split into 3 sitemaps.
+	w := get(r.ServeHTTP, "/sitemap.xml")
+	if w.Code != 200 {
+		t.Fatalf("want status 200, got %d", w.Code)
+	}
+	for _, loc := range []string{"/sitemap-1.xml", "/sitemap-3.xml"} {
+		if !strings.Contains(w.Body.String(), "http://example.org"+loc) {
+			t.Errorf("sitemap index doesn't reference %s:\n%s", loc, w.Body)
+		}
+	}
+	if w := get(r.ServeHTTP, "/sitemap-3.xml"); w.Code != 200 {
+		t.Errorf("want status 200 for last sitemap, got %d", w.Code)
+	}
+	if w := get(r.ServeHTTP, "/sitemap-4.xml"); w.Code != 404 {
+		t.Errorf("want status 404 past last sitemap, got %d", w.Code)
+	}
+}
+
+// TestPagePubTime checks that serving a page doesn't hide the publication
+// time of the page shared through the store.
+func TestPagePubTime(t *testing.T) {
+	s := newTestServer(t, nil)
+	if err := s.initTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	fp := filepath.Join(s.app.Config().PagesDir, "about.946728000.md")
+	if err := ioutil.WriteFile(fp, []byte("# About"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.app.GetPage("about") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the page")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if w := get(s.newRouter().ServeHTTP, "/about"); w.Code != 200 {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+	if s.app.GetPage("about").PubTime == nil {
+		t.Error("publication time removed from the stored page")
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"presence/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxSitemapURLs is the maximum number of URLs in a single sitemap, as
+// defined by the protocol. Larger sites are split into multiple sitemaps
+// referenced from a sitemap index.
+var maxSitemapURLs = 50000
+
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name      `xml:"urlset"`
+	NS      string        `xml:"xmlns,attr"`
+	URLs    []*sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name      `xml:"sitemapindex"`
+	NS       string        `xml:"xmlns,attr"`
+	Sitemaps []*sitemapURL `xml:"sitemap"`
+}
+
+// lastModified returns the time of the last modification of the article,
+// taken from the front matter if set, or from the file otherwise.
+func lastModified(a *model.Article) time.Time {
+	if a.Updated != nil {
+		return *a.Updated
+	}
+	return a.ModTime
+}
+
+func formatLastMod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// sitemapURLs returns the URLs of all the public pages of the site.
+func (s *Server) sitemapURLs() []*sitemapURL {
+	posts := s.app.GetAllPosts()
+	pages := s.app.GetAllPages()
+
+	var latest time.Time
+	for _, p := range posts {
+		if t := lastModified(p); t.After(latest) {
+			latest = t
+		}
+	}
+
+	urls := []*sitemapURL{
+		{Loc: s.absURL("/"), LastMod: formatLastMod(latest)},
+		{Loc: s.absURL("/archive"), LastMod: formatLastMod(latest)},
+		{Loc: s.absURL("/tags"), LastMod: formatLastMod(latest)},
+	}
+	for _, a := range append(posts, pages...) {
+		urls = append(urls, &sitemapURL{
+			Loc:     s.absURL("/" + a.Slug),
+			LastMod: formatLastMod(lastModified(a)),
+		})
+	}
+	for name := range s.app.GetTags() {
+		urls = append(urls, &sitemapURL{Loc: s.absURL(newTagData(name, 0).URL)})
+	}
+
+	return urls
+}
+
+// sitemapCount returns the number of sitemaps needed to list all the URLs.
+func sitemapCount(urls int) int {
+	return (urls + maxSitemapURLs - 1) / maxSitemapURLs
+}
+
+// handleSitemap serves the sitemap, or the sitemap index if the site has too
+// many URLs to fit in a single sitemap.
+func (s *Server) handleSitemap(w http.ResponseWriter, r *http.Request) {
+	urls := s.sitemapURLs()
+	if len(urls) <= maxSitemapURLs {
+		writeXML(w, &sitemapURLSet{NS: sitemapNS, URLs: urls})
+		return
+	}
+
+	index := &sitemapIndex{NS: sitemapNS}
+	for i := 1; i <= sitemapCount(len(urls)); i++ {
+		index.Sitemaps = append(index.Sitemaps, &sitemapURL{
+			Loc: s.absURL(fmt.Sprintf("/sitemap-%d.xml", i)),
+		})
+	}
+	writeXML(w, index)
+}
+
+// handleSitemapPart serves one of the sitemaps referenced by the sitemap
+// index.
+func (s *Server) handleSitemapPart(w http.ResponseWriter, r *http.Request) {
+	n, _ := strconv.Atoi(mux.Vars(r)["n"])
+	urls := s.sitemapURLs()
+	if len(urls) <= maxSitemapURLs || n < 1 || n > sitemapCount(len(urls)) {
+		http.Error(w, "not found", 404)
+		return
+	}
+
+	start := (n - 1) * maxSitemapURLs
+	end := start + maxSitemapURLs
+	if end > len(urls) {
+		end = len(urls)
+	}
+	writeXML(w, &sitemapURLSet{NS: sitemapNS, URLs: urls[start:end]})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+func (s *Server) handleRobots(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	b.WriteString("Disallow: /preview/\n")
+	for _, p := range s.app.Config().RobotsDisallow {
+		fmt.Fprintf(&b, "Disallow: %s\n", p)
+	}
+	fmt.Fprintf(&b, "\nSitemap: %s\n", s.absURL("/sitemap.xml"))
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
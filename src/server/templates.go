@@ -0,0 +1,222 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"presence/model"
+	"presence/theme"
+	"regexp"
+	"strings"
+	"text/template/parse"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// settleDelay is the time to wait for more file changes before acting on
+// them, as editors often save a file in several steps.
+const settleDelay = 100 * time.Millisecond
+
+func (s *Server) initTemplates() error {
+	// Create the dir and its parents if they don't exist.
+	if dir := s.app.Config().TemplatesDir; dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return s.reloadTemplates()
+}
+
+// layouts are the templates rendering the site's own pages.
+var layouts = []string{"home.html", "article.html", "archive.html", "tags.html", "tag.html", "search.html"}
+
+// loadTemplates parses the templates in the dir, layered over the default
+// theme. Files only defining templates, such as the header, are partials, and
+// the others are layouts, each grouped with all the partials.
+func loadTemplates(dir string) (map[string]*template.Template, error) {
+	fsys := theme.Layer(dir, theme.Templates)
+	fps, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	var pages, partials []string
+	for _, fp := range fps {
+		partial, err := isPartial(fsys, fp)
+		if err != nil {
+			return nil, templateError(dir, err)
+		}
+		if partial {
+			partials = append(partials, fp)
+		} else {
+			pages = append(pages, fp)
+		}
+	}
+
+	templates := make(map[string]*template.Template)
+	for _, fp := range pages {
+		group := append([]string{fp}, partials...)
+		t, err := template.ParseFS(fsys, group...)
+		if err != nil {
+			return nil, templateError(dir, err)
+		}
+		templates[t.Name()] = t
+	}
+	return templates, nil
+}
+
+// isPartial reports whether the template file has no content outside of
+// its {{define}} blocks.
+func isPartial(fsys fs.FS, name string) (bool, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return false, err
+	}
+	t, err := template.New(name).Parse(string(b))
+	if err != nil {
+		return false, err
+	}
+	return t.Tree == nil || parse.IsEmptyTree(t.Tree.Root), nil
+}
+
+var reTemplateError = regexp.MustCompile(`^template: ([^:]+):(\d+):(?:\d+:)? ?(.*)$`)
+
+// templateError rewrites parse errors, which only name the template, to
+// start with the path of the file and the line, e.g.
+// "templates/footer.html:3: unexpected EOF".
+func templateError(dir string, err error) error {
+	m := reTemplateError.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	return fmt.Errorf("%s:%s: %s", filepath.Join(dir, m[1]), m[2], m[3])
+}
+
+// template returns the current template with the name.
+func (s *Server) template(name string) (*template.Template, bool) {
+	templates, _ := s.templates.Load().(map[string]*template.Template)
+	t, ok := templates[name]
+	return t, ok
+}
+
+// articleTemplate returns the name of the layout rendering the article: the
+// one set in its front matter, else the one named after its slug, else
+// article.html.
+func (s *Server) articleTemplate(a *model.Article) string {
+	if a.Template != "" {
+		name := strings.TrimSuffix(a.Template, ".html") + ".html"
+		if _, ok := s.template(name); ok {
+			return name
+		}
+		log.Printf("warning: template %s of %s not found - using article.html\n", name, a.Slug)
+		return "article.html"
+	}
+	name := a.Slug + ".html"
+	for _, l := range layouts {
+		if name == l {
+			return "article.html"
+		}
+	}
+	if _, ok := s.template(name); ok {
+		return name
+	}
+	return "article.html"
+}
+
+// reloadTemplates parses the templates again and swaps them in. The current
+// templates are kept if parsing fails.
+func (s *Server) reloadTemplates() error {
+	templates, err := loadTemplates(s.app.Config().TemplatesDir)
+	if err != nil {
+		return err
+	}
+	s.templates.Store(templates)
+	s.changes.touch()
+	s.cache.flush()
+	return nil
+}
+
+// watchTemplates reloads the templates whenever they change. Without a
+// templates dir, the embedded theme is used, which never changes.
+func (s *Server) watchTemplates() error {
+	if s.app.Config().TemplatesDir == "" {
+		return nil
+	}
+	watcher, err := watchDirs([]string{s.app.Config().TemplatesDir}, func() {
+		if err := s.reloadTemplates(); err != nil {
+			log.Printf("couldn't reload templates, keeping the previous ones: %v\n", err)
+			return
+		}
+		log.Println("reloaded templates")
+		if s.dev != nil {
+			s.dev.changed()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	s.tplWatcher = watcher
+	return nil
+}
+
+// watchDirs calls onChange whenever files change in the dirs or their
+// subdirectories, once the changes have settled.
+func watchDirs(dirs []string, onChange func()) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	addTree := func(root string) error {
+		return filepath.Walk(root, func(fp string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return watcher.Add(fp)
+			}
+			return nil
+		})
+	}
+	for _, dir := range dirs {
+		if err := addTree(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	go func() {
+		var timer *time.Timer
+		var settled <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&fsnotify.Create == fsnotify.Create {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						addTree(event.Name)
+					}
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.NewTimer(settleDelay)
+				settled = timer.C
+			case <-settled:
+				settled = nil
+				onChange()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println("error:", err)
+			}
+		}
+	}()
+
+	return watcher, nil
+}
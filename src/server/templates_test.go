@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"presence/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplateError(t *testing.T) {
+	err := templateError("templates", errors.New(`template: footer.html:3: unexpected "}" in operand`))
+	want := filepath.Join("templates", "footer.html") + `:3: unexpected "}" in operand`
+	if err.Error() != want {
+		t.Errorf("want %q, got %q", want, err)
+	}
+}
+
+func TestWatchTemplates(t *testing.T) {
+	s := newTestServer(t, nil)
+	dir, err := ioutil.TempDir("", "presence_test_templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s.app.Config().TemplatesDir = dir
+
+	write := func(name, text string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"article", "archive", "tags", "tag", "search", "meta", "header", "footer"} {
+		write(name+".html", name)
+	}
+	write("home.html", "v1")
+	if err := s.initTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.watchTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.tplWatcher.Close()
+
+	home := func() string {
+		tpl, _ := s.template("home.html")
+		var buf bytes.Buffer
+		tpl.Execute(&buf, nil)
+		return buf.String()
+	}
+
+	// Broken templates don't replace the working ones.
+	write("home.html", "{{if}}")
+	time.Sleep(5 * settleDelay)
+	if got := home(); got != "v1" {
+		t.Errorf("want previous template, got %q", got)
+	}
+
+	write("home.html", "v2")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(home(), "v2") {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for template reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestArticleTemplate(t *testing.T) {
+	s := newTestServer(t, nil)
+	dir, err := ioutil.TempDir("", "presence_test_templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s.app.Config().TemplatesDir = dir
+
+	files := map[string]string{
+		"header.html": `{{define "header"}}<h1>{{.}}</h1>{{end}}`,
+		"photo.html":  `{{template "header" "photo"}}`,
+		"about.html":  `{{template "header" "about"}}`,
+	}
+	for _, name := range layouts {
+		files[name] = name
+	}
+	for name, text := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.initTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.template("header.html"); ok {
+		t.Error("partial loaded as a layout")
+	}
+
+	tests := []struct {
+		article *model.Article
+		want    string
+	}{
+		{&model.Article{Slug: "post"}, "article.html"},
+		{&model.Article{Slug: "post", Template: "photo"}, "photo.html"},
+		{&model.Article{Slug: "post", Template: "photo.html"}, "photo.html"},
+		{&model.Article{Slug: "post", Template: "missing"}, "article.html"},
+		{&model.Article{Slug: "about"}, "about.html"},
+		{&model.Article{Slug: "about", Template: "photo"}, "photo.html"},
+		{&model.Article{Slug: "home"}, "article.html"},
+	}
+	for _, tt := range tests {
+		if got := s.articleTemplate(tt.article); got != tt.want {
+			t.Errorf("%s (template %q): want %s, got %s", tt.article.Slug, tt.article.Template, tt.want, got)
+		}
+	}
+
+	tpl, _ := s.template("photo.html")
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "<h1>photo</h1>" {
+		t.Errorf("unexpected output: %q", got)
+	}
+
+	// Missing files are taken from the default theme.
+	os.Remove(filepath.Join(dir, "search.html"))
+	if err := s.reloadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.template("search.html"); !ok {
+		t.Error("want search.html from the default theme")
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"crypto/tls"
+	"sync/atomic"
+)
+
+// certLoader serves the TLS certificate, which can be reloaded from its files
+// while the server is running.
+type certLoader struct {
+	cert atomic.Value // *tls.Certificate
+}
+
+// load reads the certificate and key files. The current certificate is kept
+// if they're invalid.
+func (c *certLoader) load(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *certLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := c.cert.Load().(*tls.Certificate)
+	return cert, nil
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"presence/webmention"
+)
+
+type mentionData struct {
+	URL         string
+	AuthorName  string
+	AuthorURL   string
+	AuthorPhoto string
+	Content     string
+	Date        string
+}
+
+// webmentionsData groups the mentions of an article by type, for display.
+type webmentionsData struct {
+	Likes    []*mentionData
+	Reposts  []*mentionData
+	Replies  []*mentionData
+	Mentions []*mentionData
+}
+
+func (s *Server) newMentionData(m *webmention.Mention) *mentionData {
+	data := &mentionData{
+		URL:         m.URL,
+		AuthorName:  m.Author.Name,
+		AuthorURL:   m.Author.URL,
+		AuthorPhoto: m.Author.Photo,
+		Content:     m.Content,
+		Date:        s.formatDate(m.Published),
+	}
+	if data.URL == "" {
+		data.URL = m.Source
+	}
+	if data.AuthorURL == "" {
+		data.AuthorURL = data.URL
+	}
+	if data.AuthorName == "" {
+		if u, err := url.Parse(data.AuthorURL); err == nil {
+			data.AuthorName = u.Host
+		}
+	}
+	return data
+}
+
+// newWebmentionsData returns the mentions of the post, or nil if there are
+// none.
+func (s *Server) newWebmentionsData(slug string) *webmentionsData {
+	mentions := s.app.GetWebmentions(slug)
+	if len(mentions) == 0 {
+		return nil
+	}
+	data := &webmentionsData{}
+	for _, m := range mentions {
+		md := s.newMentionData(m)
+		switch m.Type {
+		case webmention.TypeLike:
+			data.Likes = append(data.Likes, md)
+		case webmention.TypeRepost:
+			data.Reposts = append(data.Reposts, md)
+		case webmention.TypeReply:
+			data.Replies = append(data.Replies, md)
+		default:
+			data.Mentions = append(data.Mentions, md)
+		}
+	}
+	return data
+}
+
+// handleWebmention is the Webmention endpoint. Mentions are accepted for
+// verification in the background.
+func (s *Server) handleWebmention(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	source := r.PostForm.Get("source")
+	target := r.PostForm.Get("target")
+
+	switch err := s.app.ReceiveWebmention(source, target); err {
+	case nil:
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("accepted\n"))
+	case webmention.ErrInvalidSource, webmention.ErrInvalidTarget:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case webmention.ErrQueueFull:
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		log.Println(err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
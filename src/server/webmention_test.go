@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebmentionEndpoint(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"hello-world.1.md": "# Hello, world!",
+	})
+	h := s.newRouter()
+
+	tests := []struct {
+		source, target string
+		want           int
+	}{
+		{"http://127.0.0.1:1/post", "http://example.org/hello-world", http.StatusAccepted},
+		{"http://other.example/post", "http://example.org/missing", http.StatusBadRequest},
+		{"http://other.example/post", "http://other.example/hello-world", http.StatusBadRequest},
+		{"mailto:someone@other.example", "http://example.org/hello-world", http.StatusBadRequest},
+		{"", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		form := url.Values{"source": {tt.source}, "target": {tt.target}}
+		req := httptest.NewRequest("POST", "/webmention", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s -> %s: want status %d, got %d", tt.source, tt.target, tt.want, w.Code)
+		}
+	}
+}
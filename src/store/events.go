@@ -28,8 +28,16 @@ func (as *ArticleStore) onCreate(event fsnotify.Event) {
 			return
 		}
 		log.Printf("renamed file: '%s' -> '%s'\n", event.Name, newName)
-	} else {
-		as.insert(article)
+		return
+	}
+
+	as.insert(article)
+	switch {
+	case article.Draft:
+		log.Printf("loaded draft: '%s'\n", article.Slug)
+	case article.PubTime.After(time.Now()):
+		log.Printf("scheduled entry: '%s' (%s)\n", article.Slug, article.PubTime)
+	default:
 		log.Printf("loaded entry: '%s'\n", article.Slug)
 	}
 }
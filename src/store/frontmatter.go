@@ -0,0 +1,207 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"presence/model"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	yamlDelim = "---"
+	tomlDelim = "+++"
+)
+
+// splitFrontMatter separates the front matter block (YAML between "---" or
+// TOML between "+++") from the rest of the document. It returns a nil map and
+// the unmodified text if no front matter is present.
+func splitFrontMatter(text []byte) (map[string]interface{}, []byte, error) {
+	delim, rest := cutLine(text)
+	delim = strings.TrimSpace(delim)
+	if delim != yamlDelim && delim != tomlDelim {
+		return nil, text, nil
+	}
+
+	var block []byte
+	for len(rest) > 0 {
+		var line string
+		line, rest = cutLine(rest)
+		if strings.TrimSpace(line) == delim {
+			return parseFrontMatter(delim, block, rest)
+		}
+		block = append(block, line...)
+		block = append(block, '\n')
+	}
+
+	return nil, text, fmt.Errorf("unterminated front matter (missing '%s')", delim)
+}
+
+func parseFrontMatter(delim string, block, body []byte) (map[string]interface{}, []byte, error) {
+	fm := make(map[string]interface{})
+	switch delim {
+	case yamlDelim:
+		if err := yaml.Unmarshal(block, &fm); err != nil {
+			return nil, body, fmt.Errorf("invalid YAML front matter: %v", err)
+		}
+	case tomlDelim:
+		tree, err := toml.LoadBytes(block)
+		if err != nil {
+			return nil, body, fmt.Errorf("invalid TOML front matter: %v", err)
+		}
+		fm = tree.ToMap()
+	}
+	// Keys are case-insensitive.
+	result := make(map[string]interface{}, len(fm))
+	for k, v := range fm {
+		result[strings.ToLower(k)] = normalizeValue(v)
+	}
+	return result, body, nil
+}
+
+// cutLine returns the first line of text without the line terminator, and
+// the remainder of the text.
+func cutLine(text []byte) (string, []byte) {
+	i := bytes.IndexByte(text, '\n')
+	if i < 0 {
+		return strings.TrimSuffix(string(text), "\r"), nil
+	}
+	return strings.TrimSuffix(string(text[:i]), "\r"), text[i+1:]
+}
+
+// applyFrontMatter sets the article fields from the front matter, overriding
+// any values derived from the filename or the document heading. Unknown keys
+// are stored in article.Params.
+func applyFrontMatter(article *model.Article, fm map[string]interface{}) error {
+	for key, value := range fm {
+		var err error
+		switch key {
+		case "title":
+			article.Title, err = toString(value)
+		case "description":
+			article.Description, err = toString(value)
+		case "author":
+			article.Author, err = toString(value)
+		case "date":
+			article.PubTime, err = toTime(value)
+		case "updated":
+			article.Updated, err = toTime(value)
+		case "tags":
+			article.Tags, err = toStringSlice(value)
+		case "draft":
+			article.Draft, err = toBool(value)
+		case "template":
+			article.Template, err = toString(value)
+		case "aliases":
+			article.Aliases, err = toStringSlice(value)
+		default:
+			if article.Params == nil {
+				article.Params = make(map[string]interface{})
+			}
+			article.Params[key] = value
+		}
+		if err != nil {
+			return fmt.Errorf("front matter field '%s': %v", key, err)
+		}
+	}
+	return nil
+}
+
+// normalizeMap converts nested map[interface{}]interface{} values produced by
+// the YAML decoder into map[string]interface{}, so that the front matter can
+// be handled the same way regardless of its format.
+func normalizeMap(m map[string]interface{}) map[string]interface{} {
+	for k, v := range m {
+		m[k] = normalizeValue(v)
+	}
+	return m
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = normalizeValue(val)
+		}
+		return m
+	case map[string]interface{}:
+		return normalizeMap(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeValue(v[i])
+		}
+		return v
+	}
+	return v
+}
+
+func toString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("want string, got %T", v)
+}
+
+func toBool(v interface{}) (bool, error) {
+	if b, ok := v.(bool); ok {
+		return b, nil
+	}
+	return false, fmt.Errorf("want boolean, got %T", v)
+}
+
+// toStringSlice accepts either a list of strings or a single comma-separated
+// string.
+func toStringSlice(v interface{}) ([]string, error) {
+	var result []string
+	switch v := v.(type) {
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				result = append(result, s)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			s, err := toString(item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, s)
+		}
+	default:
+		return nil, fmt.Errorf("want list of strings, got %T", v)
+	}
+	return result, nil
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// toTime accepts a native date value or a string in one of timeLayouts. Times
+// without a zone are interpreted as local time.
+func toTime(v interface{}) (*time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return &v, nil
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return &t, nil
+			}
+		}
+		return nil, fmt.Errorf("unrecognized date format: '%s'", v)
+	}
+	return nil, fmt.Errorf("want date, got %T", v)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"presence/model"
 	"regexp"
@@ -16,24 +17,46 @@ import (
 )
 
 func (as *ArticleStore) loadArticle(filename string) (*model.Article, error) {
-	slug, pubtime, err := parseFilename(filepath.Base(filename))
-	if err != nil {
+	if _, _, err := parseFilename(filepath.Base(filename)); err != nil {
 		return nil, err
 	}
 
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
 	contents, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	return as.parseArticle(filename, contents, info.ModTime())
+}
+
+// parseArticle creates the article from the contents of the file.
+func (as *ArticleStore) parseArticle(filename string, contents []byte, modtime time.Time) (*model.Article, error) {
+	slug, pubtime, err := parseFilename(filepath.Base(filename))
+	if err != nil {
+		return nil, err
+	}
 	if !utf8.Valid(contents) {
 		return nil, errors.New("file contains invalid UTF-8")
 	}
 
-	title, body, err := extractTitle(contents)
+	fm, body, err := splitFrontMatter(contents)
 	if err != nil {
 		return nil, err
 	}
 
+	// The first heading is only treated as the title if the front matter
+	// doesn't provide one.
+	var title string
+	if _, ok := fm["title"]; !ok {
+		title, body, err = extractTitle(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var buf bytes.Buffer
 	if len(body) == 0 {
 		buf.WriteString("<p>(empty)</p>")
@@ -45,12 +68,17 @@ func (as *ArticleStore) loadArticle(filename string) (*model.Article, error) {
 	}
 
 	article := &model.Article{
-		Slug:     slug,
-		PubTime:  pubtime,
-		Title:    title,
-		BodyRaw:  contents,
-		BodyHTML: buf.String(),
-		Filename: filename,
+		Slug:         slug,
+		PubTime:      pubtime,
+		Title:        title,
+		BodyRaw:      contents,
+		BodyMarkdown: body,
+		BodyHTML:     buf.String(),
+		Filename:     filename,
+		ModTime:      modtime,
+	}
+	if err := applyFrontMatter(article, fm); err != nil {
+		return nil, err
 	}
 
 	return article, nil
@@ -0,0 +1,341 @@
+package store
+
+import (
+	"html"
+	"math"
+	"presence/model"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	// titleBoost is the weight of a term occurrence in the title relative to
+	// an occurrence in the body.
+	titleBoost = 3.0
+)
+
+type token struct {
+	Term       string
+	Start, End int // byte offsets in the text
+}
+
+// tokenize splits the text into lowercase alphanumeric terms.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+var reTag = regexp.MustCompile(`<[^>]*>`)
+
+// HTMLText returns the text of the HTML fragment, with the tags replaced with
+// sep.
+func HTMLText(s, sep string) string {
+	return html.UnescapeString(reTag.ReplaceAllString(s, sep))
+}
+
+// BodyText returns the text of the article as rendered, so that link
+// destinations and Markdown syntax aren't indexed. Tags are replaced with
+// spaces to keep the words of adjacent elements apart. The snippets of the
+// search results must be extracted from this text, for the terms to match.
+func BodyText(article *model.Article) string {
+	return HTMLText(article.BodyHTML, " ")
+}
+
+type indexedDoc struct {
+	article *model.Article
+	length  int             // number of terms
+	title   map[string]bool // terms occurring in the title
+}
+
+// searchIndex is an inverted index of the articles. It's not safe for
+// concurrent use; the store's lock must be held.
+type searchIndex struct {
+	postings map[string]map[string][]int // term -> slug -> positions
+	docs     map[string]*indexedDoc
+	totalLen int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string][]int),
+		docs:     make(map[string]*indexedDoc),
+	}
+}
+
+func (idx *searchIndex) add(article *model.Article) {
+	doc := &indexedDoc{article: article, title: make(map[string]bool)}
+
+	// Title and body positions are separated by a gap, so that phrases can't
+	// span both.
+	pos := 0
+	for _, t := range tokenize(article.Title) {
+		doc.title[t.Term] = true
+		idx.addPosting(t.Term, article.Slug, pos)
+		pos++
+	}
+	pos++
+	for _, t := range tokenize(BodyText(article)) {
+		idx.addPosting(t.Term, article.Slug, pos)
+		pos++
+	}
+
+	doc.length = pos
+	idx.docs[article.Slug] = doc
+	idx.totalLen += doc.length
+}
+
+func (idx *searchIndex) addPosting(term, slug string, pos int) {
+	if idx.postings[term] == nil {
+		idx.postings[term] = make(map[string][]int)
+	}
+	idx.postings[term][slug] = append(idx.postings[term][slug], pos)
+}
+
+func (idx *searchIndex) remove(slug string) {
+	doc, ok := idx.docs[slug]
+	if !ok {
+		return
+	}
+	terms := tokenize(doc.article.Title + " " + BodyText(doc.article))
+	for _, t := range terms {
+		if docs, ok := idx.postings[t.Term]; ok {
+			delete(docs, slug)
+			if len(docs) == 0 {
+				delete(idx.postings, t.Term)
+			}
+		}
+	}
+	idx.totalLen -= doc.length
+	delete(idx.docs, slug)
+}
+
+// query represents a parsed search query. Each phrase is a sequence of terms
+// that must occur consecutively; single words are one-term phrases.
+type query struct {
+	phrases [][]string
+}
+
+// parseQuery parses a query consisting of words and double-quoted phrases.
+func parseQuery(q string) *query {
+	result := &query{}
+	parts := strings.Split(q, `"`)
+	for i, part := range parts {
+		var terms []string
+		for _, t := range tokenize(part) {
+			terms = append(terms, t.Term)
+		}
+		if len(terms) == 0 {
+			continue
+		}
+		if i%2 == 1 {
+			// Inside quotes.
+			result.phrases = append(result.phrases, terms)
+		} else {
+			for _, term := range terms {
+				result.phrases = append(result.phrases, []string{term})
+			}
+		}
+	}
+	return result
+}
+
+// terms returns the unique terms of the query.
+func (q *query) terms() []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, phrase := range q.phrases {
+		for _, term := range phrase {
+			if !seen[term] {
+				seen[term] = true
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+// containsPhrase checks if the terms occur consecutively in the document.
+func (idx *searchIndex) containsPhrase(slug string, phrase []string) bool {
+	first := idx.postings[phrase[0]][slug]
+	for _, start := range first {
+		match := true
+		for i, term := range phrase[1:] {
+			if !containsInt(idx.postings[term][slug], start+i+1) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(sorted []int, n int) bool {
+	i := sort.SearchInts(sorted, n)
+	return i < len(sorted) && sorted[i] == n
+}
+
+// SearchResult is a single article matching a search query.
+type SearchResult struct {
+	Article *model.Article
+	Score   float64
+	Terms   []string // query terms, for highlighting
+}
+
+// search returns the documents containing all the words and phrases of the
+// query, ranked by BM25.
+func (idx *searchIndex) search(q *query) []*SearchResult {
+	terms := q.terms()
+	if len(terms) == 0 || len(idx.docs) == 0 {
+		return nil
+	}
+
+	// Candidates must contain every term.
+	var candidates []string
+	for slug := range idx.postings[terms[0]] {
+		candidates = append(candidates, slug)
+	}
+	for _, term := range terms[1:] {
+		var filtered []string
+		for _, slug := range candidates {
+			if _, ok := idx.postings[term][slug]; ok {
+				filtered = append(filtered, slug)
+			}
+		}
+		candidates = filtered
+	}
+
+	n := float64(len(idx.docs))
+	avgLen := float64(idx.totalLen) / n
+	var results []*SearchResult
+
+	for _, slug := range candidates {
+		match := true
+		for _, phrase := range q.phrases {
+			if len(phrase) > 1 && !idx.containsPhrase(slug, phrase) {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+
+		doc := idx.docs[slug]
+		var score float64
+		for _, term := range terms {
+			df := float64(len(idx.postings[term]))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			tf := float64(len(idx.postings[term][slug]))
+			if doc.title[term] {
+				tf += titleBoost
+			}
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(doc.length)/avgLen)
+			score += idf * tf * (bm25K1 + 1) / (tf + norm)
+		}
+
+		results = append(results, &SearchResult{
+			Article: doc.article,
+			Score:   score,
+			Terms:   terms,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results
+}
+
+// Search returns the published articles matching the query, most relevant
+// first. The query consists of words, all of which must be present in the
+// article, and double-quoted phrases.
+func (as *ArticleStore) Search(q string) []*SearchResult {
+	as.mux.Lock()
+	defer as.mux.Unlock()
+	return as.index.search(parseQuery(q))
+}
+
+// TextFragment is a part of a snippet. Match is true if the fragment is one
+// of the query terms.
+type TextFragment struct {
+	Text  string
+	Match bool
+}
+
+// Snippet extracts an excerpt of the text of about width words, centered on
+// the first occurrence of any of the terms, and splits it into fragments so
+// that the matches can be highlighted.
+func Snippet(text string, terms []string, width int) []TextFragment {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	isTerm := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		isTerm[t] = true
+	}
+
+	first := 0
+	for i, t := range tokens {
+		if isTerm[t.Term] {
+			first = i
+			break
+		}
+	}
+	from := first - width/2
+	if from < 0 {
+		from = 0
+	}
+	to := from + width
+	if to > len(tokens) {
+		to = len(tokens)
+	}
+
+	var fragments []TextFragment
+	if from > 0 {
+		fragments = append(fragments, TextFragment{Text: "… "})
+	}
+	pos := tokens[from].Start
+	for _, t := range tokens[from:to] {
+		if !isTerm[t.Term] {
+			continue
+		}
+		if t.Start > pos {
+			fragments = append(fragments, TextFragment{Text: text[pos:t.Start]})
+		}
+		fragments = append(fragments, TextFragment{Text: text[t.Start:t.End], Match: true})
+		pos = t.End
+	}
+	end := tokens[to-1].End
+	if end > pos {
+		fragments = append(fragments, TextFragment{Text: text[pos:end]})
+	}
+	if to < len(tokens) {
+		fragments = append(fragments, TextFragment{Text: " …"})
+	}
+
+	return fragments
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"presence/model"
+	"reflect"
+	"testing"
+)
+
+func newIndex(docs map[string][2]string) *searchIndex {
+	idx := newSearchIndex()
+	for slug, doc := range docs {
+		idx.add(&model.Article{
+			Slug:     slug,
+			Title:    doc[0],
+			BodyHTML: "<p>" + doc[1] + "</p>",
+		})
+	}
+	return idx
+}
+
+func resultSlugs(results []*SearchResult) []string {
+	var slugs []string
+	for _, r := range results {
+		slugs = append(slugs, r.Article.Slug)
+	}
+	return slugs
+}
+
+func TestSearch(t *testing.T) {
+	idx := newIndex(map[string][2]string{
+		"go":      {"Writing Go", "Go is a programming language. I like Go."},
+		"rust":    {"Writing Rust", "Rust is a programming language too."},
+		"cooking": {"Pasta", "Boil the water, then add the pasta. Language of food."},
+	})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"go", []string{"go"}},
+		{"GO", []string{"go"}},
+		{"programming language", []string{"go", "rust"}},
+		{"writing", []string{"go", "rust"}},
+		{`"programming language"`, []string{"go", "rust"}},
+		{`"language programming"`, nil},
+		{`"language too"`, []string{"rust"}},
+		{`"writing rust" language`, []string{"rust"}},
+		{"pasta", []string{"cooking"}},
+		{"missing", nil},
+		{`""`, nil},
+	}
+
+	for _, tt := range tests {
+		got := resultSlugs(idx.search(parseQuery(tt.query)))
+		// Ranking between equally relevant documents is unspecified.
+		if len(got) == 2 && len(tt.want) == 2 && got[0] == tt.want[1] {
+			got[0], got[1] = got[1], got[0]
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.query, tt.want, got)
+		}
+	}
+
+	// Removed documents shouldn't be found.
+	idx.remove("rust")
+	if got := resultSlugs(idx.search(parseQuery("language"))); len(got) != 2 {
+		t.Errorf("want 2 results after removal, got %v", got)
+	}
+	if _, ok := idx.postings["rust"]; ok {
+		t.Error("postings not removed")
+	}
+}
+
+func TestSearchRenderedText(t *testing.T) {
+	idx := newIndex(map[string][2]string{
+		"link": {"Links", `See <a href="https://example.org/hidden">the<em>docs</em></a> &amp; more.`},
+	})
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"docs", []string{"link"}},
+		{"the", []string{"link"}},
+		{"hidden", nil},
+		{"https", nil},
+		{"amp", nil},
+		{"p", nil},
+	}
+	for _, tt := range tests {
+		if got := resultSlugs(idx.search(parseQuery(tt.query))); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := newIndex(map[string][2]string{
+		"mention":  {"Something else", "I mentioned gophers once, in a long post about many other things."},
+		"frequent": {"Gophers", "Gophers, gophers, gophers."},
+	})
+	got := resultSlugs(idx.search(parseQuery("gophers")))
+	want := []string{"frequent", "mention"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := "one two three four five six seven eight nine ten"
+	got := Snippet(text, []string{"six"}, 4)
+	want := []TextFragment{
+		{Text: "… "},
+		{Text: "four five "},
+		{Text: "six", Match: true},
+		{Text: " seven"},
+		{Text: " …"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
@@ -1,9 +1,13 @@
 package store
 
 import (
+	"bytes"
+	"log"
 	"presence/model"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/yuin/goldmark"
@@ -11,19 +15,33 @@ import (
 
 // ArticleStore contains a collection of articles generated from Markdown files
 // present in a directory. Changes to the files are immediately reflected in
-// the store.
+// the store. Unpublished articles (drafts and articles scheduled for future
+// publication) are kept separately and can only be retrieved with
+// GetUnpublished.
 type ArticleStore struct {
-	Dir      string
-	items    map[string]*model.Article
-	watcher  *fsnotify.Watcher
-	markdown goldmark.Markdown
-	mux      sync.Mutex
+	Dir         string
+	items       map[string]*model.Article
+	unpublished map[string]*model.Article
+	tags        map[string]map[string]bool // tag -> set of slugs
+	timers      map[string]*time.Timer     // slug -> scheduled publication
+	index       *searchIndex
+	subscribers []func(Event)
+	watcher     *fsnotify.Watcher
+	markdown    goldmark.Markdown
+	generation  uint64    // increased by every change
+	modified    time.Time // time of the last change
+	mux         sync.Mutex
 }
 
 func NewArticleStore(dirpath string) (*ArticleStore, error) {
 	as := &ArticleStore{
-		Dir:   dirpath,
-		items: make(map[string]*model.Article),
+		Dir:         dirpath,
+		items:       make(map[string]*model.Article),
+		unpublished: make(map[string]*model.Article),
+		tags:        make(map[string]map[string]bool),
+		timers:      make(map[string]*time.Timer),
+		index:       newSearchIndex(),
+		modified:    time.Now(),
 	}
 	if err := as.initWatcher(); err != nil {
 		return nil, err
@@ -35,14 +53,152 @@ func NewArticleStore(dirpath string) (*ArticleStore, error) {
 
 func (as *ArticleStore) insert(article *model.Article) {
 	as.mux.Lock()
+	// The watcher reports the files written by the store itself, which have
+	// already been inserted.
+	if current := as.lookupLocked(article.Slug); current != nil && sameFile(current, article) {
+		as.mux.Unlock()
+		return
+	}
+	old := as.removeLocked(article.Slug)
+	as.touchLocked()
+	published := false
+	now := time.Now()
+	switch {
+	case article.Draft:
+		as.unpublished[article.Slug] = article
+	case article.PubTime != nil && article.PubTime.After(now):
+		as.unpublished[article.Slug] = article
+		as.timers[article.Slug] = time.AfterFunc(article.PubTime.Sub(now), func() {
+			as.publish(article)
+		})
+	default:
+		as.items[article.Slug] = article
+		as.indexTags(article)
+		as.index.add(article)
+		published = true
+	}
+	as.mux.Unlock()
+
+	switch {
+	case published:
+		as.notify(Event{EventPublish, article})
+	case old != nil:
+		as.notify(Event{EventRemove, old})
+	default:
+		as.notify(Event{EventDraft, article})
+	}
+}
+
+// publish moves a scheduled article to the published items. It's a no-op if
+// the article has since been replaced or removed.
+func (as *ArticleStore) publish(article *model.Article) {
+	as.mux.Lock()
+	if as.unpublished[article.Slug] != article {
+		as.mux.Unlock()
+		return
+	}
+	delete(as.unpublished, article.Slug)
+	delete(as.timers, article.Slug)
 	as.items[article.Slug] = article
+	as.indexTags(article)
+	as.index.add(article)
+	as.touchLocked()
 	as.mux.Unlock()
+
+	log.Printf("published scheduled entry: '%s'\n", article.Slug)
+	as.notify(Event{EventPublish, article})
 }
 
 func (as *ArticleStore) remove(slug string) {
 	as.mux.Lock()
-	delete(as.items, slug)
+	old := as.removeLocked(slug)
+	as.touchLocked()
 	as.mux.Unlock()
+
+	if old != nil {
+		as.notify(Event{EventRemove, old})
+	}
+}
+
+// lookupLocked returns the article with the given slug, published or not.
+// Caller must hold the lock.
+func (as *ArticleStore) lookupLocked(slug string) *model.Article {
+	if article, ok := as.items[slug]; ok {
+		return article
+	}
+	return as.unpublished[slug]
+}
+
+// sameFile reports whether both articles were loaded from the same version of
+// the same file.
+func sameFile(a, b *model.Article) bool {
+	return a.Filename == b.Filename && a.ModTime.Equal(b.ModTime) && bytes.Equal(a.BodyRaw, b.BodyRaw)
+}
+
+// removeLocked removes the article from the store, returning it if it was
+// published. Caller must hold the lock.
+func (as *ArticleStore) removeLocked(slug string) *model.Article {
+	old, ok := as.items[slug]
+	if ok {
+		as.unindexTags(old)
+		as.index.remove(slug)
+	}
+	delete(as.items, slug)
+	delete(as.unpublished, slug)
+	if timer, ok := as.timers[slug]; ok {
+		timer.Stop()
+		delete(as.timers, slug)
+	}
+	return old
+}
+
+// touchLocked records a change to the articles. Caller must hold the lock.
+func (as *ArticleStore) touchLocked() {
+	as.generation++
+	as.modified = time.Now()
+}
+
+// State returns the generation of the store, which is increased by every
+// change to its articles, and the time of the last change.
+func (as *ArticleStore) State() (uint64, time.Time) {
+	as.mux.Lock()
+	defer as.mux.Unlock()
+	return as.generation, as.modified
+}
+
+// NormalizeTag returns the canonical form of the tag used for lookups. Tags
+// are a single segment of the tag page URLs, so slashes are replaced with
+// dashes.
+func NormalizeTag(tag string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(tag)), "/", "-")
+}
+
+// indexTags adds the article to the tag index. Caller must hold the lock.
+func (as *ArticleStore) indexTags(article *model.Article) {
+	for _, tag := range article.Tags {
+		tag = NormalizeTag(tag)
+		if tag == "" {
+			continue
+		}
+		if as.tags[tag] == nil {
+			as.tags[tag] = make(map[string]bool)
+		}
+		as.tags[tag][article.Slug] = true
+	}
+}
+
+// unindexTags removes the article from the tag index. Caller must hold the
+// lock.
+func (as *ArticleStore) unindexTags(article *model.Article) {
+	for _, tag := range article.Tags {
+		tag = NormalizeTag(tag)
+		if slugs, ok := as.tags[tag]; ok {
+			delete(slugs, article.Slug)
+			if len(slugs) == 0 {
+				delete(as.tags, tag)
+			}
+		}
+	}
 }
 func (as *ArticleStore) Len() int {
 	as.mux.Lock()
@@ -61,12 +217,26 @@ func (as *ArticleStore) Get(slug string) *model.Article {
 	return nil
 }
 
+// GetUnpublished returns the unpublished *model.Article from the store given
+// its slug, or nil, if it doesn't exist.
+func (as *ArticleStore) GetUnpublished(slug string) *model.Article {
+	as.mux.Lock()
+	defer as.mux.Unlock()
+	if article, ok := as.unpublished[slug]; ok {
+		return article
+	}
+	return nil
+}
+
+// GetByFilename returns the article loaded from the file, published or not.
 func (as *ArticleStore) GetByFilename(filename string) *model.Article {
 	as.mux.Lock()
 	defer as.mux.Unlock()
-	for _, v := range as.items {
-		if v.Filename == filename {
-			return v
+	for _, m := range []map[string]*model.Article{as.items, as.unpublished} {
+		for _, v := range m {
+			if v.Filename == filename {
+				return v
+			}
 		}
 	}
 	return nil
@@ -97,6 +267,43 @@ func (as *ArticleStore) GetAll() []*model.Article {
 	for _, v := range as.items {
 		values = append(values, v)
 	}
+	sortArticles(values)
+
+	return values
+}
+
+// GetByTag returns all the articles with the given tag, sorted by pubtime
+// (most recent first).
+func (as *ArticleStore) GetByTag(tag string) []*model.Article {
+	as.mux.Lock()
+	defer as.mux.Unlock()
+
+	slugs := as.tags[NormalizeTag(tag)]
+	values := make([]*model.Article, 0, len(slugs))
+	for slug := range slugs {
+		values = append(values, as.items[slug])
+	}
+	sortArticles(values)
+
+	return values
+}
+
+// Tags returns all the tags in the store along with the number of articles
+// tagged with each.
+func (as *ArticleStore) Tags() map[string]int {
+	as.mux.Lock()
+	defer as.mux.Unlock()
+
+	counts := make(map[string]int, len(as.tags))
+	for tag, slugs := range as.tags {
+		counts[tag] = len(slugs)
+	}
+	return counts
+}
+
+// sortArticles sorts the articles by pubtime (most recent first), then by
+// title.
+func sortArticles(values []*model.Article) {
 	sort.SliceStable(values, func(i, j int) bool {
 		return values[i].Title < values[j].Title
 	})
@@ -106,10 +313,14 @@ func (as *ArticleStore) GetAll() []*model.Article {
 		}
 		return values[i].PubTime.Unix() > values[j].PubTime.Unix()
 	})
-
-	return values
 }
 
 func (as *ArticleStore) Close() {
 	as.watcher.Close()
+	as.mux.Lock()
+	for slug, timer := range as.timers {
+		timer.Stop()
+		delete(as.timers, slug)
+	}
+	as.mux.Unlock()
 }
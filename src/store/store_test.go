@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -160,3 +162,328 @@ func TestCreateWithoutTimestamp(t *testing.T) {
 		t.Errorf(`article (slug: %s) pubtime is nil, want non-nil`, slug)
 	}
 }
+
+// TestFrontMatter checks if the metadata from YAML and TOML front matter
+// blocks takes precedence over the filename and the heading.
+func TestFrontMatter(t *testing.T) {
+	as := setup(t)
+	defer teardown(t, as)
+
+	docs := map[string]string{
+		"yaml-post.1.md": strings.Join([]string{
+			"---",
+			"title: Front matter title",
+			"date: 2020-05-01T10:00:00Z",
+			"updated: 2020-05-02",
+			"description: A short summary",
+			"tags: [go, web]",
+			"draft: true",
+			"template: photo",
+			"aliases: [old-slug]",
+			"camera: X100F",
+			"---",
+			"# Heading title",
+			"",
+			"Body text.",
+		}, "\n"),
+		"toml-post.1.md": strings.Join([]string{
+			"+++",
+			`title = "Front matter title"`,
+			"date = 2020-05-01T10:00:00Z",
+			`updated = "2020-05-02"`,
+			`description = "A short summary"`,
+			`tags = ["go", "web"]`,
+			"draft = true",
+			`template = "photo"`,
+			`aliases = ["old-slug"]`,
+			`camera = "X100F"`,
+			"+++",
+			"# Heading title",
+			"",
+			"Body text.",
+		}, "\n"),
+	}
+
+	for fname, text := range docs {
+		fpath := filepath.Join(as.Dir, fname)
+		if err := ioutil.WriteFile(fpath, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait()
+
+	wantDate := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	wantUpdated := time.Date(2020, 5, 2, 0, 0, 0, 0, time.Local)
+
+	for _, slug := range []string{"yaml-post", "toml-post"} {
+		article, err := as.loadArticle(filepath.Join(as.Dir, slug+".1.md"))
+		if err != nil {
+			t.Fatalf("%s: couldn't load article: %v", slug, err)
+		}
+		if article.Title != "Front matter title" {
+			t.Errorf(`%s: want title "Front matter title", got "%s"`, slug, article.Title)
+		}
+		if article.PubTime == nil || !article.PubTime.Equal(wantDate) {
+			t.Errorf("%s: want pubtime %v, got %v", slug, wantDate, article.PubTime)
+		}
+		if article.Updated == nil || !article.Updated.Equal(wantUpdated) {
+			t.Errorf("%s: want updated %v, got %v", slug, wantUpdated, article.Updated)
+		}
+		if article.Description != "A short summary" {
+			t.Errorf("%s: unexpected description: %q", slug, article.Description)
+		}
+		if strings.Join(article.Tags, ",") != "go,web" {
+			t.Errorf("%s: unexpected tags: %v", slug, article.Tags)
+		}
+		if !article.Draft {
+			t.Errorf("%s: want draft", slug)
+		}
+		if article.Template != "photo" {
+			t.Errorf("%s: unexpected template: %q", slug, article.Template)
+		}
+		if strings.Join(article.Aliases, ",") != "old-slug" {
+			t.Errorf("%s: unexpected aliases: %v", slug, article.Aliases)
+		}
+		if article.Params["camera"] != "X100F" {
+			t.Errorf("%s: unexpected params: %v", slug, article.Params)
+		}
+		// The heading isn't the title, so it should be kept in the body.
+		if !strings.Contains(article.BodyHTML, "Heading title") {
+			t.Errorf("%s: heading missing from body: %s", slug, article.BodyHTML)
+		}
+	}
+}
+
+// TestTags checks if the tag index is kept up to date as the files change.
+func TestTags(t *testing.T) {
+	as := setup(t)
+	defer teardown(t, as)
+
+	write := func(fname, text string) {
+		fpath := filepath.Join(as.Dir, fname)
+		if err := ioutil.WriteFile(fpath, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("first.1.md", "---\ntags: [Go, web]\n---\nFirst.")
+	write("second.2.md", "---\ntags: [go]\n---\nSecond.")
+	wait()
+
+	if got := as.GetByTag("go"); len(got) != 2 || got[0].Slug != "second" {
+		t.Errorf("want [second first] tagged 'go', got %d articles", len(got))
+	}
+	if got := as.Tags(); got["go"] != 2 || got["web"] != 1 || len(got) != 2 {
+		t.Errorf("unexpected tag counts: %v", got)
+	}
+
+	// Retagging the article should update the index.
+	write("first.1.md", "---\ntags: [misc]\n---\nFirst.")
+	wait()
+
+	if got := as.GetByTag("go"); len(got) != 1 {
+		t.Errorf("want 1 article tagged 'go', got %d", len(got))
+	}
+	if got := as.Tags(); got["web"] != 0 || got["misc"] != 1 {
+		t.Errorf("unexpected tag counts after retagging: %v", got)
+	}
+
+	// Slashes can't be used in the tag page URLs.
+	write("third.3.md", "---\ntags: [CI/CD]\n---\nThird.")
+	wait()
+
+	if got := as.GetByTag("ci/cd"); len(got) != 1 || as.Tags()["ci-cd"] != 1 {
+		t.Errorf("want 1 article tagged 'ci-cd', got %d", len(got))
+	}
+
+	// Deleted articles shouldn't be listed under their tags.
+	if err := os.Remove(filepath.Join(as.Dir, "second.2.md")); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	if got := as.GetByTag("go"); len(got) != 0 {
+		t.Errorf("want no articles tagged 'go', got %d", len(got))
+	}
+}
+
+// TestDrafts checks if drafts are kept out of the listings until published.
+func TestDrafts(t *testing.T) {
+	as := setup(t)
+	defer teardown(t, as)
+
+	fpath := filepath.Join(as.Dir, "draft.1.md")
+	text := "---\ntags: [misc]\ndraft: true\n---\n# Draft\n\nNot ready."
+	if err := ioutil.WriteFile(fpath, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	if as.Get("draft") != nil {
+		t.Error("draft accessible by Get")
+	}
+	if as.GetUnpublished("draft") == nil {
+		t.Error("draft not accessible by GetUnpublished")
+	}
+	if n := len(as.GetAll()); n != 0 {
+		t.Errorf("want 0 articles listed, got %d", n)
+	}
+	if n := len(as.GetByTag("misc")); n != 0 {
+		t.Errorf("want 0 articles tagged 'misc', got %d", n)
+	}
+
+	// Publishing the draft should move it to the listings.
+	text = "---\ntags: [misc]\n---\n# Draft\n\nReady."
+	if err := ioutil.WriteFile(fpath, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	if as.Get("draft") == nil {
+		t.Error("published article not accessible by Get")
+	}
+	if as.GetUnpublished("draft") != nil {
+		t.Error("published article still accessible by GetUnpublished")
+	}
+	if n := len(as.GetByTag("misc")); n != 1 {
+		t.Errorf("want 1 article tagged 'misc', got %d", n)
+	}
+}
+
+// TestScheduled checks if articles with a future pubtime are held back until
+// that time.
+func TestScheduled(t *testing.T) {
+	as := setup(t)
+	defer teardown(t, as)
+
+	pubtime := time.Now().Add(500 * time.Millisecond)
+	fname := fmt.Sprintf("scheduled.%d.md", pubtime.Unix()+1)
+	fpath := filepath.Join(as.Dir, fname)
+	if err := ioutil.WriteFile(fpath, []byte("# Scheduled"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	if as.Get("scheduled") != nil {
+		t.Error("scheduled article published early")
+	}
+	if as.GetUnpublished("scheduled") == nil {
+		t.Error("scheduled article not accessible by GetUnpublished")
+	}
+
+	time.Sleep(time.Until(pubtime.Add(time.Second)) + 50*time.Millisecond)
+
+	if as.Get("scheduled") == nil {
+		t.Error("scheduled article not published on time")
+	}
+	if as.GetUnpublished("scheduled") != nil {
+		t.Error("published article still accessible by GetUnpublished")
+	}
+}
+
+// TestWrite checks the store's file operations.
+func TestWrite(t *testing.T) {
+	as := setup(t)
+	defer teardown(t, as)
+
+	if _, err := as.Create("bad.slug", []byte("# Bad")); err != ErrInvalidSlug {
+		t.Errorf("want ErrInvalidSlug, got %v", err)
+	}
+
+	article, err := as.Create("hello", []byte("# Hello"))
+	if err != nil {
+		t.Fatalf("couldn't create article: %v", err)
+	}
+	if as.Get("hello") == nil || article.Title != "Hello" || article.PubTime == nil {
+		t.Errorf("created article not loaded correctly: %+v", article)
+	}
+	if filepath.Dir(article.Filename) != as.Dir || !isValidFilename(article.Filename) {
+		t.Errorf("unexpected filename: %s", article.Filename)
+	}
+	if _, err := as.Create("hello", []byte("# Hello")); err != ErrExists {
+		t.Errorf("want ErrExists, got %v", err)
+	}
+
+	updated, err := as.Update("hello", []byte("# Hello again"))
+	if err != nil {
+		t.Fatalf("couldn't update article: %v", err)
+	}
+	if updated.Filename != article.Filename || as.Get("hello").Title != "Hello again" {
+		t.Errorf("article not updated in place: %+v", updated)
+	}
+
+	// Invalid contents shouldn't replace the file.
+	if _, err := as.Update("hello", []byte("---\ntitle: [\n---\n")); err == nil {
+		t.Error("want error for invalid front matter")
+	}
+	wait()
+	if got := as.Get("hello"); got == nil || got.Title != "Hello again" {
+		t.Error("article replaced with invalid contents")
+	}
+
+	if err := as.Delete("hello"); err != nil {
+		t.Fatalf("couldn't delete article: %v", err)
+	}
+	if as.Get("hello") != nil || fileExists(article.Filename) {
+		t.Error("article not deleted")
+	}
+	if err := as.Delete("hello"); err != ErrNotFound {
+		t.Errorf("want ErrNotFound, got %v", err)
+	}
+
+	// No temporary files should be left behind.
+	wait()
+	files, err := ioutil.ReadDir(as.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("want empty dir, got %d files", len(files))
+	}
+}
+
+// TestSubscribe checks the events sent on changes to the articles.
+func TestSubscribe(t *testing.T) {
+	as := setup(t)
+	defer teardown(t, as)
+
+	var mux sync.Mutex
+	var events []string
+	as.Subscribe(func(e Event) {
+		mux.Lock()
+		defer mux.Unlock()
+		var kind string
+		switch e.Type {
+		case EventPublish:
+			kind = "publish"
+		case EventRemove:
+			kind = "remove"
+		case EventDraft:
+			kind = "draft"
+		}
+		// The watcher reports the files written by the store, which must not
+		// be notified twice.
+		events = append(events, kind+" "+e.Article.Slug)
+	})
+
+	steps := []func() error{
+		func() error { _, err := as.Create("hello", []byte("# Hello")); return err },
+		func() error { _, err := as.Update("hello", []byte("---\ndraft: true\n---\n")); return err },
+		func() error { _, err := as.Update("hello", []byte("# Hello again")); return err },
+		func() error { return as.Delete("hello") },
+		func() error { _, err := as.Create("wip", []byte("---\ndraft: true\n---\n# WIP")); return err },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+		wait()
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	want := []string{"publish hello", "remove hello", "publish hello", "remove hello", "draft wip"}
+	if strings.Join(events, ", ") != strings.Join(want, ", ") {
+		t.Errorf("want events %v, got %v", want, events)
+	}
+}
@@ -0,0 +1,43 @@
+package store
+
+import "presence/model"
+
+// EventType is the kind of change to the articles.
+type EventType int
+
+const (
+	// EventPublish is sent when an article is published, or a published
+	// article is updated.
+	EventPublish EventType = iota
+	// EventRemove is sent when a published article is removed or unpublished.
+	EventRemove
+	// EventDraft is sent when an unpublished article (a draft or a scheduled
+	// article) is added or updated.
+	EventDraft
+)
+
+// Event describes a change to the articles.
+type Event struct {
+	Type    EventType
+	Article *model.Article
+}
+
+// Subscribe registers the function to be called on every change to the
+// articles. Articles loaded before the call don't generate events.
+// The function is called synchronously by the goroutine making the change,
+// so it should return quickly.
+func (as *ArticleStore) Subscribe(f func(Event)) {
+	as.mux.Lock()
+	defer as.mux.Unlock()
+	as.subscribers = append(as.subscribers, f)
+}
+
+// notify sends the event to the subscribers. The lock must not be held.
+func (as *ArticleStore) notify(e Event) {
+	as.mux.Lock()
+	subscribers := as.subscribers
+	as.mux.Unlock()
+	for _, f := range subscribers {
+		f(e)
+	}
+}
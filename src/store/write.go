@@ -0,0 +1,91 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"presence/atomicfile"
+	"presence/model"
+	"time"
+)
+
+var (
+	ErrInvalidSlug = errors.New("invalid slug")
+	ErrExists      = errors.New("article already exists")
+	ErrNotFound    = errors.New("article not found")
+)
+
+// IsValidSlug checks if the slug can be used in an article filename.
+func IsValidSlug(slug string) bool {
+	s, _, err := parseFilename(slug + ".md")
+	return err == nil && s == slug
+}
+
+// Lookup returns the article with the given slug, published or not, or nil
+// if it doesn't exist.
+func (as *ArticleStore) Lookup(slug string) *model.Article {
+	if article := as.Get(slug); article != nil {
+		return article
+	}
+	return as.GetUnpublished(slug)
+}
+
+// Create writes a new article file with the current timestamp and loads it
+// into the store.
+func (as *ArticleStore) Create(slug string, contents []byte) (*model.Article, error) {
+	if !IsValidSlug(slug) {
+		return nil, ErrInvalidSlug
+	}
+	if as.Lookup(slug) != nil {
+		return nil, ErrExists
+	}
+	now := time.Now()
+	filename := filepath.Join(as.Dir, makeFilename(&model.Article{Slug: slug, PubTime: &now}))
+	return as.write(filename, contents)
+}
+
+// Update replaces the contents of the existing article's file and reloads it.
+func (as *ArticleStore) Update(slug string, contents []byte) (*model.Article, error) {
+	article := as.Lookup(slug)
+	if article == nil {
+		return nil, ErrNotFound
+	}
+	return as.write(article.Filename, contents)
+}
+
+// Delete removes the article's file and the article from the store.
+func (as *ArticleStore) Delete(slug string) error {
+	article := as.Lookup(slug)
+	if article == nil {
+		return ErrNotFound
+	}
+	if err := os.Remove(article.Filename); err != nil {
+		return err
+	}
+	as.remove(slug)
+	return nil
+}
+
+// write atomically replaces the file contents by renaming a temporary file,
+// then loads the article. The article is loaded here so that it's immediately
+// available to the caller; the watcher picks up the change as well, which
+// insert ignores.
+func (as *ArticleStore) write(filename string, contents []byte) (*model.Article, error) {
+	// Validate the contents before replacing the file.
+	if _, err := as.parseArticle(filename, contents, time.Now()); err != nil {
+		return nil, err
+	}
+
+	// The temporary file is ignored by the watcher, as its name is not a valid
+	// article filename.
+	if err := atomicfile.Write(filename, contents, 0644); err != nil {
+		return nil, err
+	}
+
+	article, err := as.loadArticle(filename)
+	if err != nil {
+		return nil, err
+	}
+	as.insert(article)
+	return article, nil
+}
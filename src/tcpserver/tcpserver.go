@@ -0,0 +1,95 @@
+// Package tcpserver implements the connection handling shared by the servers
+// of the protocols answering a single request per connection, e.g. Gemini
+// and Gopher.
+package tcpserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed is returned by Serve after Shutdown.
+var ErrServerClosed = errors.New("server closed")
+
+// Server accepts connections and handles each in its own goroutine, until
+// it's shut down. The zero value is ready to use.
+type Server struct {
+	mux      sync.Mutex
+	listener net.Listener
+	closed   bool
+	conns    sync.WaitGroup
+}
+
+// Serve opens the listener with listen, and passes the accepted connections
+// to handle, which may be called concurrently. The connections are closed
+// once handle returns. Serve returns when the listener fails, or with
+// ErrServerClosed after Shutdown.
+func (s *Server) Serve(listen func() (net.Listener, error), handle func(net.Conn)) error {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return ErrServerClosed
+	}
+	l, err := listen()
+	if err != nil {
+		s.mux.Unlock()
+		return err
+	}
+	s.listener = l
+	s.mux.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mux.Lock()
+			closed := s.closed
+			s.mux.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+// Addr returns the address of the listener, or nil if Serve hasn't opened it
+// yet.
+func (s *Server) Addr() net.Addr {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Shutdown stops accepting connections, and waits for the active ones to be
+// done or for the context to expire.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mux.Lock()
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
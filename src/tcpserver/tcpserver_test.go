@@ -0,0 +1,55 @@
+package tcpserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	var s Server
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Serve(func() (net.Listener, error) {
+			return net.Listen("tcp", "127.0.0.1:0")
+		}, func(conn net.Conn) {
+			conn.Write([]byte("hello"))
+		})
+	}()
+
+	var addr string
+	for deadline := time.Now().Add(5 * time.Second); addr == ""; {
+		if a := s.Addr(); a != nil {
+			addr = a.String()
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The connection is closed after the handler returns.
+	got, err := ioutil.ReadAll(conn)
+	conn.Close()
+	if err != nil || string(got) != "hello" {
+		t.Errorf("want hello, got %q, %v", got, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != ErrServerClosed {
+		t.Errorf("want ErrServerClosed, got %v", err)
+	}
+	if err := s.Serve(nil, nil); err != ErrServerClosed {
+		t.Errorf("want ErrServerClosed after shutdown, got %v", err)
+	}
+}
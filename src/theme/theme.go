@@ -0,0 +1,124 @@
+// Package theme holds the default templates and static files, embedded in
+// the binary so that the site works without a theme of its own.
+package theme
+
+import (
+	"embed"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+//go:embed templates static
+var files embed.FS
+
+// Templates and Static are the default templates and static files.
+var (
+	Templates = sub("templates")
+	Static    = sub("static")
+)
+
+func sub(dir string) fs.FS {
+	fsys, err := fs.Sub(files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// Layer returns a file system with the files in dir, falling back to the
+// files in base when they don't exist in dir. An empty dir means base alone.
+func Layer(dir string, base fs.FS) fs.FS {
+	if dir == "" {
+		return base
+	}
+	return &layered{top: os.DirFS(dir), base: base}
+}
+
+type layered struct {
+	top, base fs.FS
+}
+
+func (l *layered) Open(name string) (fs.File, error) {
+	f, err := l.top.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l.base.Open(name)
+	}
+	return f, err
+}
+
+// ReadDir lists the entries of the directory in both file systems, the ones
+// in top replacing those with the same name in base.
+func (l *layered) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries := make(map[string]fs.DirEntry)
+	found := false
+	for _, fsys := range []fs.FS{l.base, l.top} {
+		list, err := fs.ReadDir(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, e := range list {
+			entries[e.Name()] = e
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+// Eject copies the files in fsys to dir, keeping the files which already
+// exist there. It returns the paths of the copied files.
+func Eject(fsys fs.FS, dir string) ([]string, error) {
+	var copied []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		if err := CopyFile(fsys, name, target); err != nil {
+			return err
+		}
+		copied = append(copied, target)
+		return nil
+	})
+	return copied, err
+}
+
+// CopyFile copies the named file in fsys to dst, replacing it if it exists.
+func CopyFile(fsys fs.FS, name, dst string) error {
+	in, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,83 @@
+package theme
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presence_test_theme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "footer.html"), []byte("custom"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "photo.html"), []byte("photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := Layer(dir, Templates)
+	b, err := fs.ReadFile(fsys, "footer.html")
+	if err != nil || string(b) != "custom" {
+		t.Errorf("want the overridden file, got %q (%v)", b, err)
+	}
+	if _, err := fs.ReadFile(fsys, "header.html"); err != nil {
+		t.Errorf("want the default file, got %v", err)
+	}
+
+	names, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults, _ := fs.Glob(Templates, "*.html")
+	if len(names) != len(defaults)+1 {
+		t.Errorf("want the default templates and photo.html, got %v", names)
+	}
+
+	// A missing dir leaves the defaults.
+	names, _ = fs.Glob(Layer(filepath.Join(dir, "missing"), Templates), "*.html")
+	if !reflect.DeepEqual(names, defaults) {
+		t.Errorf("want %v, got %v", defaults, names)
+	}
+}
+
+func TestEject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presence_test_theme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "css"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	style := filepath.Join(dir, "css", "style.css")
+	if err := ioutil.WriteFile(style, []byte("custom"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := Eject(Static, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copied) != 0 {
+		t.Errorf("want existing files kept, copied %v", copied)
+	}
+	if b, _ := ioutil.ReadFile(style); string(b) != "custom" {
+		t.Errorf("existing file overwritten: %q", b)
+	}
+
+	os.Remove(style)
+	copied, err = Eject(Static, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(copied, []string{style}) {
+		t.Errorf("want %v copied, got %v", style, copied)
+	}
+}
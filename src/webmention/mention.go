@@ -0,0 +1,32 @@
+// Package webmention implements receiving and sending Webmentions, as
+// specified in https://www.w3.org/TR/webmention/.
+package webmention
+
+import "time"
+
+// Mention types, derived from the microformats of the source.
+const (
+	TypeMention  = "mention"
+	TypeReply    = "reply"
+	TypeLike     = "like"
+	TypeRepost   = "repost"
+	TypeBookmark = "bookmark"
+)
+
+type Author struct {
+	Name  string `json:"name,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Photo string `json:"photo,omitempty"`
+}
+
+// Mention is a verified webmention.
+type Mention struct {
+	Source    string     `json:"source"`
+	Target    string     `json:"target"`
+	Type      string     `json:"type"`
+	URL       string     `json:"url,omitempty"` // canonical URL of the source entry
+	Author    Author     `json:"author"`
+	Content   string     `json:"content,omitempty"` // plain text
+	Published *time.Time `json:"published,omitempty"`
+	Verified  time.Time  `json:"verified"`
+}
@@ -0,0 +1,269 @@
+package webmention
+
+import (
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxContentLength is the number of characters of the source entry's content
+// kept in the mention.
+const maxContentLength = 500
+
+// typeProperties maps the h-entry properties linking to the target to the
+// mention types, in order of precedence.
+var typeProperties = []struct {
+	class, typ string
+}{
+	{"u-in-reply-to", TypeReply},
+	{"u-like-of", TypeLike},
+	{"u-repost-of", TypeRepost},
+	{"u-bookmark-of", TypeBookmark},
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func classes(n *html.Node) []string {
+	for _, a := range n.Attr {
+		if a.Key == "class" {
+			return strings.Fields(a.Val)
+		}
+	}
+	return nil
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range classes(n) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// isRoot checks if the element is a microformats root, e.g. h-entry.
+func isRoot(n *html.Node) bool {
+	for _, c := range classes(n) {
+		if strings.HasPrefix(c, "h-") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// find returns the first element in the subtree, in document order, for
+// which the function returns true.
+func find(n *html.Node, f func(*html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && f(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := find(c, f); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findProperty returns the first element with the property class belonging
+// to the microformats object rooted at n. Properties of nested objects are
+// skipped.
+func findProperty(n *html.Node, class string) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if hasClass(c, class) {
+			return c
+		}
+		if isRoot(c) {
+			continue
+		}
+		if found := findProperty(c, class); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// blockElements are separated by whitespace in the text content.
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Br: true, atom.Li: true,
+	atom.Blockquote: true, atom.Pre: true, atom.H1: true, atom.H2: true,
+	atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+// textContent returns the text of the subtree with whitespace collapsed.
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+			return
+		case n.DataAtom == atom.Script || n.DataAtom == atom.Style:
+			return
+		case blockElements[n.DataAtom]:
+			b.WriteByte(' ')
+			defer b.WriteByte(' ')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// resolve returns the absolute URL of the reference, or an empty string if
+// it's invalid.
+func resolve(base *url.URL, ref string) string {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+// linkValue returns the URL of a u-* property.
+func linkValue(n *html.Node, base *url.URL) string {
+	for _, key := range []string{"href", "src"} {
+		if v := attr(n, key); v != "" {
+			return resolve(base, v)
+		}
+	}
+	if isRoot(n) {
+		if u := findProperty(n, "u-url"); u != nil {
+			return linkValue(u, base)
+		}
+	}
+	return ""
+}
+
+// linksTo checks if any link in the subtree points to the target.
+func linksTo(n *html.Node, base *url.URL, target string) bool {
+	return find(n, func(n *html.Node) bool {
+		for _, key := range []string{"href", "src"} {
+			if v := attr(n, key); v != "" && resolve(base, v) == target {
+				return true
+			}
+		}
+		return false
+	}) != nil
+}
+
+func parseDate(s string) *time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
+
+// parseAuthor extracts the author from a p-author or u-author property,
+// which is either an h-card or a plain name or link.
+func parseAuthor(n *html.Node, base *url.URL) Author {
+	if !isRoot(n) {
+		if n.DataAtom == atom.A {
+			return Author{Name: textContent(n), URL: linkValue(n, base)}
+		}
+		return Author{Name: textContent(n)}
+	}
+	var author Author
+	if name := findProperty(n, "p-name"); name != nil {
+		author.Name = textContent(name)
+	} else {
+		author.Name = textContent(n)
+	}
+	if u := findProperty(n, "u-url"); u != nil {
+		author.URL = linkValue(u, base)
+	} else if n.DataAtom == atom.A {
+		author.URL = linkValue(n, base)
+	}
+	if photo := findProperty(n, "u-photo"); photo != nil {
+		author.Photo = linkValue(photo, base)
+	} else if img := find(n, func(n *html.Node) bool { return n.DataAtom == atom.Img }); img != nil {
+		author.Photo = resolve(base, attr(img, "src"))
+	}
+	return author
+}
+
+// parseMention builds the mention from the first h-entry of the source
+// document. Sources without an h-entry result in a generic mention.
+func parseMention(doc *html.Node, base *url.URL, target string) *Mention {
+	m := &Mention{Type: TypeMention}
+	entry := find(doc, func(n *html.Node) bool { return hasClass(n, "h-entry") })
+	if entry == nil {
+		return m
+	}
+
+	for _, p := range typeProperties {
+		prop := findProperty(entry, p.class)
+		if prop != nil && (linkValue(prop, base) == target || linksTo(prop, base, target)) {
+			m.Type = p.typ
+			break
+		}
+	}
+	if n := findProperty(entry, "u-url"); n != nil {
+		m.URL = linkValue(n, base)
+	}
+	if n := findProperty(entry, "p-author"); n != nil {
+		m.Author = parseAuthor(n, base)
+	} else if n := findProperty(entry, "u-author"); n != nil {
+		m.Author = parseAuthor(n, base)
+	}
+	if n := findProperty(entry, "dt-published"); n != nil {
+		if v := attr(n, "datetime"); v != "" {
+			m.Published = parseDate(v)
+		} else {
+			m.Published = parseDate(textContent(n))
+		}
+	}
+	if m.Type == TypeReply || m.Type == TypeMention {
+		if n := findProperty(entry, "e-content"); n != nil {
+			m.Content = truncate(textContent(n), maxContentLength)
+		} else if n := findProperty(entry, "p-content"); n != nil {
+			m.Content = truncate(textContent(n), maxContentLength)
+		}
+	}
+
+	return m
+}
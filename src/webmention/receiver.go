@@ -0,0 +1,315 @@
+package webmention
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"presence/atomicfile"
+	"presence/safehttp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// fileSuffix is appended to the key to get the name of the file storing
+	// the mentions of the target.
+	fileSuffix = ".webmentions.json"
+
+	queueSize     = 100
+	maxSourceSize = 1 << 20
+	fetchTimeout  = 10 * time.Second
+)
+
+var (
+	ErrInvalidSource = errors.New("invalid source URL")
+	ErrInvalidTarget = errors.New("invalid target URL")
+	ErrQueueFull     = errors.New("too many pending webmentions")
+
+	errGone   = errors.New("source is gone")
+	errNoLink = errors.New("source doesn't link to target")
+)
+
+type request struct {
+	source, target, key string
+}
+
+// Receiver verifies incoming webmentions in the background and keeps the
+// verified ones, grouped by a key identifying the target, e.g. its slug. The
+// mentions of each target are persisted in a JSON file in Dir.
+type Receiver struct {
+	Dir string
+	// Client fetches the sources. By default, it only connects to public
+	// addresses.
+	Client *http.Client
+
+	mentions map[string][]*Mention
+	changes  uint64    // increased by every change to the mentions
+	modified time.Time // time of the last change
+	queue    chan *request
+	done     chan struct{}
+	closed   bool
+	wg       sync.WaitGroup
+
+	subscribers []func(key string)
+	mux         sync.Mutex
+}
+
+// NewReceiver loads the mentions stored in dir and starts the verification
+// worker.
+func NewReceiver(dir string) (*Receiver, error) {
+	r := &Receiver{
+		Dir:      dir,
+		Client:   safehttp.NewClient(fetchTimeout),
+		mentions: make(map[string][]*Mention),
+		modified: time.Now(),
+		queue:    make(chan *request, queueSize),
+		done:     make(chan struct{}),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r, nil
+}
+
+func (r *Receiver) load() error {
+	files, err := filepath.Glob(filepath.Join(r.Dir, "*"+fileSuffix))
+	if err != nil {
+		return err
+	}
+	for _, fp := range files {
+		data, err := ioutil.ReadFile(fp)
+		if err != nil {
+			return err
+		}
+		var mentions []*Mention
+		if err := json.Unmarshal(data, &mentions); err != nil {
+			log.Printf("couldn't load webmentions from '%s': %v\n", fp, err)
+			continue
+		}
+		key := strings.TrimSuffix(filepath.Base(fp), fileSuffix)
+		r.mentions[key] = mentions
+	}
+	return nil
+}
+
+// Enqueue queues the webmention for verification. The key identifies the
+// target in the store.
+func (r *Receiver) Enqueue(source, target, key string) error {
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidSource
+	}
+	if source == target {
+		return ErrInvalidSource
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.closed {
+		return ErrQueueFull
+	}
+	select {
+	case r.queue <- &request{source, target, key}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (r *Receiver) run() {
+	defer r.wg.Done()
+	for {
+		select {
+		case req := <-r.queue:
+			r.process(req)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// process verifies the webmention and updates the stored mentions. A mention
+// is removed if its source no longer links to the target, or is gone.
+func (r *Receiver) process(req *request) {
+	m, err := r.verify(req.source, req.target)
+	switch err {
+	case nil:
+		if err := r.add(req.key, m); err != nil {
+			log.Printf("couldn't save webmention: %v\n", err)
+			return
+		}
+		r.notify(req.key)
+		log.Printf("received webmention: '%s' -> '%s' (%s)\n", m.Source, m.Target, m.Type)
+	case errGone, errNoLink:
+		removed, saveErr := r.remove(req.key, req.source)
+		if saveErr != nil {
+			log.Printf("couldn't save webmentions: %v\n", saveErr)
+			return
+		}
+		if removed {
+			r.notify(req.key)
+		}
+		log.Printf("rejected webmention from '%s': %v\n", req.source, err)
+	default:
+		log.Printf("couldn't verify webmention from '%s': %v\n", req.source, err)
+	}
+}
+
+// verify fetches the source and checks that it links to the target.
+func (r *Receiver) verify(source, target string) (*Mention, error) {
+	req, err := http.NewRequest("GET", source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html, */*;q=0.5")
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return nil, errGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSourceSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var m *Mention
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt == "text/html" || mt == "application/xhtml+xml" {
+		doc, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		// Relative links are resolved against the final URL, after redirects.
+		base := resp.Request.URL
+		if !linksTo(doc, base, target) {
+			return nil, errNoLink
+		}
+		m = parseMention(doc, base, target)
+	} else {
+		if !bytes.Contains(body, []byte(target)) {
+			return nil, errNoLink
+		}
+		m = &Mention{Type: TypeMention}
+	}
+
+	m.Source = source
+	m.Target = target
+	m.Verified = time.Now()
+	return m, nil
+}
+
+// Mentions returns the mentions of the target identified by the key, in the
+// order they were first received.
+func (r *Receiver) Mentions(key string) []*Mention {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]*Mention(nil), r.mentions[key]...)
+}
+
+// State returns the number of changes to the mentions since they were
+// loaded, and the time of the last change.
+func (r *Receiver) State() (uint64, time.Time) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.changes, r.modified
+}
+
+// add adds the mention, replacing an earlier one from the same source.
+func (r *Receiver) add(key string, m *Mention) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	mentions := r.mentions[key]
+	replaced := false
+	for i, old := range mentions {
+		if old.Source == m.Source {
+			mentions[i] = m
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		mentions = append(mentions, m)
+	}
+	r.mentions[key] = mentions
+	r.changes++
+	r.modified = time.Now()
+	return r.save(key)
+}
+
+// remove removes the mention from the source, and reports whether there was
+// one.
+func (r *Receiver) remove(key, source string) (bool, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	mentions := r.mentions[key]
+	for i, m := range mentions {
+		if m.Source == source {
+			r.mentions[key] = append(mentions[:i:i], mentions[i+1:]...)
+			r.changes++
+			r.modified = time.Now()
+			return true, r.save(key)
+		}
+	}
+	return false, nil
+}
+
+// Subscribe registers the function to be called with the key of the target
+// whenever its mentions change. The function is called by the verification
+// worker, so it should return quickly.
+func (r *Receiver) Subscribe(f func(key string)) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.subscribers = append(r.subscribers, f)
+}
+
+// notify calls the subscribers with the key. The lock must not be held.
+func (r *Receiver) notify(key string) {
+	r.mux.Lock()
+	subscribers := r.subscribers
+	r.mux.Unlock()
+	for _, f := range subscribers {
+		f(key)
+	}
+}
+
+// save writes the mentions of the target to its file. The lock must be held.
+func (r *Receiver) save(key string) error {
+	return atomicfile.WriteJSON(filepath.Join(r.Dir, key+fileSuffix), r.mentions[key], 0644)
+}
+
+// Close stops the verification worker. Pending webmentions are dropped.
+func (r *Receiver) Close() {
+	r.mux.Lock()
+	if r.closed {
+		r.mux.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.done)
+	r.mux.Unlock()
+	r.wg.Wait()
+}
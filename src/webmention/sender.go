@@ -0,0 +1,419 @@
+package webmention
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"presence/atomicfile"
+	"presence/safehttp"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxSendAttempts is the number of attempts at sending a webmention before
+// giving up.
+const maxSendAttempts = 5
+
+// retryDelay is the delay before the first retry of a failed send. It doubles
+// with each attempt.
+var retryDelay = time.Minute
+
+// Send statuses of a target.
+const (
+	statusPending    = "pending"
+	statusSent       = "sent"
+	statusFailed     = "failed"
+	statusNoEndpoint = "no-endpoint"
+	statusSkipped    = "skipped" // linked before the first run
+)
+
+type sendStatus struct {
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts,omitempty"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// sendRecord holds the targets notified for a version of the source content,
+// identified by its hash.
+type sendRecord struct {
+	Hash    string                 `json:"hash"`
+	Targets map[string]*sendStatus `json:"targets"`
+}
+
+// Sender sends webmentions for the links in published content. The send
+// log, persisted in a JSON file, records the targets notified for each
+// version of each source, so that content is only handled once, even across
+// restarts. Failed sends are retried with exponential backoff.
+type Sender struct {
+	// Client discovers the endpoints and sends the webmentions. By default,
+	// it only connects to public addresses.
+	Client *http.Client
+
+	path   string
+	log    map[string]*sendRecord // source -> record
+	synced bool                   // whether log was loaded from a previous run
+	wake   chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+	mux    sync.Mutex
+}
+
+// NewSender loads the send log from the file and starts sending the pending
+// webmentions.
+func NewSender(path string) (*Sender, error) {
+	s := &Sender{
+		Client: safehttp.NewClient(fetchTimeout),
+		path:   path,
+		log:    make(map[string]*sendRecord),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	synced, err := atomicfile.ReadJSON(path, &s.log)
+	if err != nil {
+		return nil, err
+	}
+	s.synced = synced
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+// Notify schedules webmentions for the source, given its current HTML
+// content. All the external links are notified, as well as the targets
+// linked from earlier versions of the content, so that they can update or
+// remove their mentions. Content that was already handled is ignored. Pass
+// empty content for sources that have been removed.
+func (s *Sender) Notify(source, content string) {
+	hash := contentHash(content)
+	targets := externalLinks(source, content)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	old := s.log[source]
+	if old != nil && old.Hash == hash {
+		return
+	}
+	rec := &sendRecord{Hash: hash, Targets: make(map[string]*sendStatus)}
+	now := time.Now()
+	for _, t := range targets {
+		rec.Targets[t] = &sendStatus{Status: statusPending, NextAttempt: now}
+	}
+	if old != nil {
+		for t := range old.Targets {
+			rec.Targets[t] = &sendStatus{Status: statusPending, NextAttempt: now}
+		}
+	}
+	s.log[source] = rec
+	if err := s.save(); err != nil {
+		log.Printf("couldn't save webmention log: %v\n", err)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Sync catches up with the changes made to the sources while the server
+// wasn't running, given the current content of all of them. Sources missing
+// from contents are notified as removed. On the first run, the links of the
+// existing content are only recorded, not notified.
+func (s *Sender) Sync(contents map[string]string) {
+	s.mux.Lock()
+	if !s.synced {
+		for source, content := range contents {
+			rec := &sendRecord{Hash: contentHash(content), Targets: make(map[string]*sendStatus)}
+			for _, t := range externalLinks(source, content) {
+				rec.Targets[t] = &sendStatus{Status: statusSkipped}
+			}
+			s.log[source] = rec
+		}
+		s.synced = true
+		if err := s.save(); err != nil {
+			log.Printf("couldn't save webmention log: %v\n", err)
+		}
+		s.mux.Unlock()
+		return
+	}
+	var removed []string
+	for source := range s.log {
+		if _, ok := contents[source]; !ok {
+			removed = append(removed, source)
+		}
+	}
+	s.mux.Unlock()
+
+	for source, content := range contents {
+		s.Notify(source, content)
+	}
+	for _, source := range removed {
+		s.Notify(source, "")
+	}
+}
+
+// contentHash identifies a version of the content in the send log.
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:16])
+}
+
+// externalLinks returns the absolute URLs of the links in the HTML content
+// which point to other hosts than the source.
+func externalLinks(source, content string) []string {
+	base, err := url.Parse(source)
+	if err != nil || content == "" {
+		return nil
+	}
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	find(doc, func(n *html.Node) bool {
+		if n.DataAtom != atom.A {
+			return false
+		}
+		u, err := base.Parse(strings.TrimSpace(attr(n, "href")))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+			strings.EqualFold(u.Host, base.Host) {
+			return false
+		}
+		u.Fragment = ""
+		seen[u.String()] = true
+		return false
+	})
+
+	links := make([]string, 0, len(seen))
+	for link := range seen {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	return links
+}
+
+// save writes the send log. The lock must be held.
+func (s *Sender) save() error {
+	return atomicfile.WriteJSON(s.path, s.log, 0644)
+}
+
+func (s *Sender) run() {
+	defer s.wg.Done()
+	for {
+		source, target, rec, wait := s.next()
+		if rec != nil && wait <= 0 {
+			s.send(source, target, rec)
+			continue
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if rec != nil {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-s.wake:
+		case <-timeout:
+		case <-s.done:
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// next returns the pending webmention with the earliest scheduled attempt,
+// and the time until the attempt. The record is nil if nothing is pending.
+func (s *Sender) next() (string, string, *sendRecord, time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var source, target string
+	var rec *sendRecord
+	var earliest time.Time
+	for src, r := range s.log {
+		for t, st := range r.Targets {
+			if st.Status != statusPending {
+				continue
+			}
+			if rec == nil || st.NextAttempt.Before(earliest) {
+				source, target, rec, earliest = src, t, r, st.NextAttempt
+			}
+		}
+	}
+	return source, target, rec, time.Until(earliest)
+}
+
+// send discovers the target's endpoint and sends the webmention, then
+// records the result.
+func (s *Sender) send(source, target string, rec *sendRecord) {
+	endpoint, retry, err := s.discover(target)
+	if err == nil && endpoint != "" {
+		retry, err = s.post(endpoint, source, target)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	// The content may have changed in the meantime, in which case the
+	// target has been rescheduled.
+	if s.log[source] != rec {
+		return
+	}
+	st := rec.Targets[target]
+	st.Attempts++
+	switch {
+	case err == nil && endpoint == "":
+		st.Status = statusNoEndpoint
+	case err == nil:
+		st.Status = statusSent
+		st.Error = ""
+		log.Printf("sent webmention: '%s' -> '%s'\n", source, target)
+	case retry && st.Attempts < maxSendAttempts:
+		st.NextAttempt = time.Now().Add(retryDelay << uint(st.Attempts-1))
+		st.Error = err.Error()
+		log.Printf("couldn't send webmention to '%s' (retrying at %s): %v\n",
+			target, st.NextAttempt.Format(time.RFC3339), err)
+	default:
+		st.Status = statusFailed
+		st.Error = err.Error()
+		log.Printf("couldn't send webmention to '%s': %v\n", target, err)
+	}
+	if err := s.save(); err != nil {
+		log.Printf("couldn't save webmention log: %v\n", err)
+	}
+}
+
+// isTemporary checks if the request should be retried after a response with
+// the status code.
+func isTemporary(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+var (
+	reLink = regexp.MustCompile(`<([^>]*)>([^,]*)`)
+	reRel  = regexp.MustCompile(`(?i);\s*rel\s*=\s*(?:"([^"]*)"|([^\s;]+))`)
+)
+
+// linkHeaderEndpoint returns the URL of the webmention endpoint from the
+// Link header values, or an empty string if it's not present.
+func linkHeaderEndpoint(values []string) (string, bool) {
+	for _, v := range values {
+		for _, m := range reLink.FindAllStringSubmatch(v, -1) {
+			for _, rel := range reRel.FindAllStringSubmatch(m[2], -1) {
+				for _, r := range strings.Fields(rel[1] + " " + rel[2]) {
+					if strings.EqualFold(r, "webmention") {
+						return m[1], true
+					}
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// htmlEndpoint returns the href of the first link or a element with the
+// webmention relation.
+func htmlEndpoint(doc *html.Node) (string, bool) {
+	n := find(doc, func(n *html.Node) bool {
+		if n.DataAtom != atom.Link && n.DataAtom != atom.A {
+			return false
+		}
+		if !hasAttr(n, "href") {
+			return false
+		}
+		for _, r := range strings.Fields(attr(n, "rel")) {
+			if strings.EqualFold(r, "webmention") {
+				return true
+			}
+		}
+		return false
+	})
+	if n == nil {
+		return "", false
+	}
+	return attr(n, "href"), true
+}
+
+// discover returns the webmention endpoint of the target, or an empty string
+// if it doesn't have one. The bool is true if a failed request should be
+// retried.
+func (s *Sender) discover(target string) (string, bool, error) {
+	resp, err := s.Client.Get(target)
+	if err != nil {
+		return "", true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", isTemporary(resp.StatusCode), fmt.Errorf("endpoint discovery: unexpected status: %s", resp.Status)
+	}
+
+	// Relative URLs are resolved against the final URL, after redirects.
+	base := resp.Request.URL
+	if href, ok := linkHeaderEndpoint(resp.Header.Values("Link")); ok {
+		return resolve(base, href), false, nil
+	}
+
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt != "text/html" && mt != "application/xhtml+xml" {
+		return "", false, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSourceSize))
+	if err != nil {
+		return "", true, err
+	}
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return "", false, err
+	}
+	if href, ok := htmlEndpoint(doc); ok {
+		return resolve(base, href), false, nil
+	}
+	return "", false, nil
+}
+
+// post sends the webmention to the endpoint. The bool is true if a failed
+// request should be retried.
+func (s *Sender) post(endpoint, source, target string) (bool, error) {
+	resp, err := s.Client.PostForm(endpoint, url.Values{
+		"source": {source},
+		"target": {target},
+	})
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxSourceSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return isTemporary(resp.StatusCode), fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Close stops sending webmentions. The pending ones are sent after the next
+// start.
+func (s *Sender) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
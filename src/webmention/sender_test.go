@@ -0,0 +1,165 @@
+package webmention
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExternalLinks(t *testing.T) {
+	content := `<p>
+		<a href="https://other.example/a#section">A</a>
+		<a href="https://other.example/a">A again</a>
+		<a href="/local">Local</a>
+		<a href="http://example.org/absolute-local">Local</a>
+		<a href="mailto:someone@example.org">Mail</a>
+		<img src="https://other.example/image.png">
+	</p>`
+	got := fmt.Sprint(externalLinks("http://example.org/post", content))
+	if want := "[https://other.example/a]"; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestLinkHeaderEndpoint(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{`<https://example.org/wm>; rel="webmention"`, "https://example.org/wm"},
+		{`<https://example.org/wm>; rel=webmention`, "https://example.org/wm"},
+		{`<https://example.org/a>; rel="other", </wm?x=1,2>; rel="a webmention"`, "/wm?x=1,2"},
+		{`<https://example.org/a>; rel="other"`, ""},
+	}
+	for _, tt := range tests {
+		if got, _ := linkHeaderEndpoint([]string{tt.header}); got != tt.want {
+			t.Errorf("%s: want %q, got %q", tt.header, tt.want, got)
+		}
+	}
+}
+
+// targetSite is a stand-in for a site receiving webmentions.
+type targetSite struct {
+	mux      sync.Mutex
+	failures int // number of requests to fail before accepting
+	received []string
+}
+
+func (tg *targetSite) count() int {
+	tg.mux.Lock()
+	defer tg.mux.Unlock()
+	return len(tg.received)
+}
+
+func (tg *targetSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tg.mux.Lock()
+	defer tg.mux.Unlock()
+	switch r.URL.Path {
+	case "/header":
+		w.Header().Set("Link", `</endpoint>; rel="webmention"`)
+	case "/html":
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<link rel="webmention" href="endpoint?from=html">`))
+	case "/endpoint":
+		if tg.failures > 0 {
+			tg.failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		r.ParseForm()
+		tg.received = append(tg.received, r.PostForm.Get("target"))
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presence_test_webmention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "sent.json")
+
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = 10 * time.Millisecond
+
+	tg := &targetSite{failures: 2}
+	srv := httptest.NewServer(tg)
+	defer srv.Close()
+
+	content := fmt.Sprintf(`<a href="%s/header">1</a> <a href="%s/html">2</a> <a href="%s/none">3</a>`,
+		srv.URL, srv.URL, srv.URL)
+
+	s, err := NewSender(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Client = srv.Client()
+	s.Notify("http://example.org/post", content)
+	waitFor(t, "webmentions", func() bool { return tg.count() == 2 })
+	s.Close()
+
+	// The same content isn't sent again after a restart, but changed content
+	// is sent to both the new and the old targets.
+	s, err = NewSender(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Client = srv.Client()
+	s.Notify("http://example.org/post", content)
+	time.Sleep(50 * time.Millisecond)
+	if n := tg.count(); n != 2 {
+		t.Errorf("want 2 webmentions after restart, got %d", n)
+	}
+	s.Notify("http://example.org/post", fmt.Sprintf(`<a href="%s/html">2</a>`, srv.URL))
+	waitFor(t, "webmentions for update", func() bool { return tg.count() == 4 })
+}
+
+// TestSenderSync checks that the links of the existing content are only
+// recorded on the first run, and that later changes are sent.
+func TestSenderSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presence_test_webmention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "sent.json")
+
+	tg := &targetSite{}
+	srv := httptest.NewServer(tg)
+	defer srv.Close()
+
+	s, err := NewSender(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Client = srv.Client()
+	s.Sync(map[string]string{
+		"http://example.org/old":     fmt.Sprintf(`<a href="%s/header">1</a>`, srv.URL),
+		"http://example.org/removed": fmt.Sprintf(`<a href="%s/html">2</a>`, srv.URL),
+	})
+	time.Sleep(50 * time.Millisecond)
+	if n := tg.count(); n != 0 {
+		t.Errorf("want no webmentions on the first run, got %d", n)
+	}
+	s.Close()
+
+	// Changes made while stopped are sent on the next run.
+	s, err = NewSender(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Client = srv.Client()
+	s.Sync(map[string]string{
+		"http://example.org/old": fmt.Sprintf(`<a href="%s/header">1</a> updated`, srv.URL),
+	})
+	waitFor(t, "webmentions", func() bool { return tg.count() == 2 })
+}
@@ -0,0 +1,183 @@
+package webmention
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const target = "http://example.org/hello-world"
+
+func TestParseMention(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want Mention
+	}{
+		{
+			"plain link",
+			`<p>See <a href="http://example.org/hello-world">this</a>.</p>`,
+			Mention{Type: TypeMention},
+		},
+		{
+			"reply",
+			`<article class="h-entry">
+				<a class="p-author h-card" href="/me"><img src="/me.jpg">Jane</a>
+				<a class="u-in-reply-to" href="http://example.org/hello-world">In reply to</a>
+				<div class="e-content">Great   <b>post</b>!</div>
+				<a class="u-url" href="/replies/1"><time class="dt-published" datetime="2020-01-02T03:04:05Z">Jan 2</time></a>
+			</article>`,
+			Mention{
+				Type:    TypeReply,
+				URL:     "http://source.example/replies/1",
+				Author:  Author{Name: "Jane", URL: "http://source.example/me", Photo: "http://source.example/me.jpg"},
+				Content: "Great post!",
+			},
+		},
+		{
+			"like with nested author",
+			`<div class="h-entry">
+				<div class="p-author h-card"><span class="p-name">Joe</span> <a class="u-url" href="https://joe.example/">site</a></div>
+				<div class="u-like-of h-cite"><a class="u-url" href="http://example.org/hello-world">Hello</a></div>
+				<div class="e-content">Liked it</div>
+			</div>`,
+			Mention{
+				Type:   TypeLike,
+				Author: Author{Name: "Joe", URL: "https://joe.example/"},
+			},
+		},
+		{
+			"repost of another page",
+			`<div class="h-entry">
+				<a class="u-repost-of" href="http://other.example/">Other</a>
+				<p class="p-content">Also <a href="http://example.org/hello-world">this</a></p>
+			</div>`,
+			Mention{Type: TypeMention, Content: "Also this"},
+		},
+	}
+
+	base, _ := url.Parse("http://source.example/posts/1")
+	for _, tt := range tests {
+		doc, err := html.Parse(strings.NewReader(tt.doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !linksTo(doc, base, target) {
+			t.Errorf("%s: link to target not found", tt.name)
+		}
+		got := parseMention(doc, base, target)
+		got.Published = nil
+		if *got != tt.want {
+			t.Errorf("%s: want %+v, got %+v", tt.name, tt.want, *got)
+		}
+	}
+}
+
+// source is a stand-in for the site sending the webmentions.
+type source struct {
+	mux    sync.Mutex
+	status int
+	body   string
+}
+
+func (s *source) set(status int, body string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.status, s.body = status, body
+}
+
+func (s *source) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(s.status)
+	w.Write([]byte(s.body))
+}
+
+// waitFor polls until the condition is met or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presence_test_webmention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := &source{}
+	srv := httptest.NewServer(src)
+	defer srv.Close()
+
+	r, err := NewReceiver(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// The default client refuses to connect to the test server on the
+	// loopback address.
+	r.Client = srv.Client()
+	changed := make(chan string, 10)
+	r.Subscribe(func(key string) { changed <- key })
+
+	if err := r.Enqueue("ftp://example.org/", target, "hello-world"); err != ErrInvalidSource {
+		t.Errorf("want ErrInvalidSource, got %v", err)
+	}
+
+	// Not linking to the target.
+	src.set(200, `<a href="http://example.org/other">Other</a>`)
+	if err := r.Enqueue(srv.URL, target, "hello-world"); err != nil {
+		t.Fatal(err)
+	}
+
+	src.set(200, `<div class="h-entry"><a class="u-like-of" href="`+target+`">Like</a></div>`)
+	if err := r.Enqueue(srv.URL, target, "hello-world"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "mention", func() bool { return len(r.Mentions("hello-world")) == 1 })
+	if m := r.Mentions("hello-world")[0]; m.Type != TypeLike || m.Source != srv.URL {
+		t.Errorf("unexpected mention: %+v", m)
+	}
+
+	// The mentions are persisted.
+	r2, err := NewReceiver(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r2.Mentions("hello-world"); len(got) != 1 || got[0].Type != TypeLike {
+		t.Errorf("mentions not persisted: %+v", got)
+	}
+	r2.Close()
+
+	// Updates replace the mention, and deleted sources remove it.
+	src.set(200, `<p><a href="`+target+`">Mention</a></p>`)
+	r.Enqueue(srv.URL, target, "hello-world")
+	waitFor(t, "update", func() bool {
+		m := r.Mentions("hello-world")
+		return len(m) == 1 && m[0].Type == TypeMention
+	})
+	src.set(410, "")
+	r.Enqueue(srv.URL, target, "hello-world")
+	waitFor(t, "deletion", func() bool { return len(r.Mentions("hello-world")) == 0 })
+
+	// Subscribers are notified of the additions, updates and deletions.
+	waitFor(t, "notifications", func() bool { return len(changed) >= 3 })
+	if key := <-changed; key != "hello-world" {
+		t.Errorf("unexpected key %q", key)
+	}
+}